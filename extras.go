@@ -6,11 +6,29 @@ package flag
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 )
 
 type FlagSetExtras struct {
@@ -18,6 +36,42 @@ type FlagSetExtras struct {
 	envPrefix          string
 	readUnderscoreFile bool
 	trimFileContent    bool
+	// file system the config files are read from, nil for the os
+	configFS fs.FS
+	// config files loaded when no config file is given
+	configSearchPaths []string
+	// separator of the config file list, empty for DefaultConfigSeparator
+	configSeparator string
+	// standard input for the config file "-", nil for os.Stdin
+	stdin io.Reader
+	// if standard input has been read during the current parse
+	stdinRead bool
+	// expansion of ${VAR} references in values
+	interpolation Interpolation
+	// environment of the last ParseEnv, nil for the os environment
+	env map[string]string
+	// sources applied after the command line, nil for DefaultSources
+	sources []Source
+	// flags set on the command line, while sources of higher precedence apply
+	commandLine map[string]*Flag
+	// origins of the values of the flags in actual
+	origins map[string]Origin
+	// config files read during the current parse
+	configRead []*configFile
+	// entries of the config file list expanded during the current parse
+	configExpanded []configExpansion
+	// values read from sources while reloading, nil when setting flags
+	staged map[string][]stagedValue
+	// if the sources are read again by a reload
+	reloading bool
+	// if the values of the sources are validated before setting the flags
+	transactional bool
+	// flags holding the value of a secret flag through a ${flag:...} reference
+	secrets map[string]bool
+	// names of the flags which may be changed when reloading
+	reloadable map[string]bool
+	// held for writing by reloads, see FlagSet.RLock
+	reloadMu sync.RWMutex
 }
 
 var (
@@ -90,6 +144,7 @@ func (f *FlagSet) ParseEnv(environ []string) error {
 
 	// Create a map of all environment variables
 	env := parseEnvToMap(environ)
+	f.env = env
 
 	// Iterate over all registered flags
 	for _, registeredFlag := range f.formal {
@@ -115,6 +170,7 @@ func (f *FlagSet) ParseEnv(environ []string) error {
 
 		envKey := flagNameToEnvKey(flag.Name, f.envPrefix)
 
+		origin := Origin{Kind: OriginEnv, Key: envKey}
 		envValue, exist := env[envKey]
 		if !exist {
 			// parsing of _FILE
@@ -133,19 +189,39 @@ func (f *FlagSet) ParseEnv(environ []string) error {
 			if err != nil {
 				return f.failf("could not read file %s provided by %s", envValue, envKey)
 			}
+			origin = Origin{Kind: OriginEnv, Key: envKey, File: envValue}
 			envValue = string(fileBytes)
 			if f.trimFileContent {
 				envValue = strings.TrimSpace(envValue)
 			}
+		} else if f.interpolation != InterpolationOff {
+			expanded, err := f.interpolate(flag, envValue)
+			if err != nil {
+				shown, err := redact(flag, envValue, err)
+				return f.failf("invalid value %q for environment variable %s: %v", shown, name, err)
+			}
+			envValue = expanded
+		}
+
+		if f.staged != nil {
+			if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() {
+				envValue = parseEnvBool(envValue)
+			}
+			f.staged[name] = append(f.staged[name], stagedValue{envValue, "", origin})
+			f.actual[name] = flag
+			continue
 		}
 
+		f.overrideCommandLine(flag)
 		if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() { // special case: doesn't need an arg
 			if err := fv.Set(parseEnvBool(envValue)); err != nil {
-				return f.failf("invalid boolean value %q for environment variable %s: %v", envValue, name, err)
+				shown, err := redact(flag, envValue, err)
+				return f.failf("invalid boolean value %q for environment variable %s: %v", shown, name, err)
 			}
 		} else {
 			if err := flag.Value.Set(envValue); err != nil {
-				return f.failf("invalid value %q for environment variable %s: %v", envValue, name, err)
+				shown, err := redact(flag, envValue, err)
+				return f.failf("invalid value %q for environment variable %s: %v", shown, name, err)
 			}
 		}
 
@@ -154,6 +230,7 @@ func (f *FlagSet) ParseEnv(environ []string) error {
 			f.actual = make(map[string]*Flag)
 		}
 		f.actual[name] = flag
+		f.setOrigin(name, origin)
 
 	}
 	return nil
@@ -169,194 +246,2929 @@ func NewFlagSetWithExtras(name string, errorHandling ErrorHandling, envPrefix st
 	return f
 }
 
-// ParseFile parses flags from the file in path.
+// Interpolation controls the expansion of variable references in values of
+// environment variables, plain text and YAML config files.
+type Interpolation int
+
+// These constants cause [FlagSet.SetInterpolation] to behave as described.
+const (
+	// InterpolationOff leaves values unchanged.
+	InterpolationOff Interpolation = iota
+	// InterpolationLenient expands undefined variables to an empty string.
+	InterpolationLenient
+	// InterpolationStrict reports undefined variables as an error.
+	InterpolationStrict
+)
+
+// SetInterpolation sets the expansion of variable references in values of
+// environment variables, plain text and YAML config files; it is off by
+// default. `${VAR}` is replaced by the environment variable VAR,
+// `${VAR:-default}` by default if VAR is undefined or empty, and `$$` by a
+// single "$". Any other "$" is kept as is.
 //
-// If the file is a YAML (.yaml, .yaml) file, it will be loaded as actual YAML.
-func (f *FlagSet) ParseFile(path string) error {
-	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
-		return f.parseFile_YAML(path)
-	}
+// `${flag:name}` is replaced by the value of the flag name after parsing,
+// once all sources have been applied. It is expanded in the values of all
+// flags holding a string, whatever their source, in the order of their
+// references; cyclic references are an error. In these values `$$` stands
+// for a single "$" also on the command line, so `$${flag:name}` is the text
+// `${flag:name}`.
+//
+// Variables are looked up in the environment last passed to
+// [FlagSet.ParseEnv], which is the os environment during [FlagSet.Parse].
+// Values of ENVKEY_FILE files are not expanded.
+func (f *FlagSet) SetInterpolation(mode Interpolation) {
+	f.interpolation = mode
+}
 
-	return f.parseFile_PlainText(path)
+// lookupEnv returns the value of the environment variable key from the
+// environment last passed to ParseEnv, or from the os environment.
+func (f *FlagSet) lookupEnv(key string) (string, bool) {
+	if f.env != nil {
+		value, ok := f.env[key]
+		return value, ok
+	}
+	return os.LookupEnv(key)
 }
 
-// parseFile_PlainText parses flags from the file in path.
-// Same format as commandline argumens, newlines and lines beginning with a
-// "#" charater are ignored. Flags already set will be ignored.
-func (f *FlagSet) parseFile_PlainText(path string) error {
+// interpolate expands the variable references in s, the value of flag, see
+// SetInterpolation. Flag references are resolved after parsing: if flag
+// holds a string and the expanded value contains one, "$" stays escaped as
+// "$$" for resolveFlagReferences, so that an escaped `$${flag:name}` is not
+// taken for a reference.
+func (f *FlagSet) interpolate(flag *Flag, s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
 
-	// Extract arguments from file
-	fp, err := os.Open(path)
+	escaped, err := f.interpolateEscaped(s)
 	if err != nil {
-		return fmt.Errorf("failed to open file '%s': %v", path, err)
+		return "", err
 	}
-	defer fp.Close()
-
-	scanner := bufio.NewScanner(fp)
-
-scan:
-	for scanner.Scan() {
-		line := scanner.Text()
+	if _, ok := stringOf(flag); ok && strings.Contains(escaped, "${"+flagReferencePrefix) {
+		return escaped, nil
+	}
+	return strings.ReplaceAll(escaped, "$$", "$"), nil
+}
 
-		// Ignore empty lines
-		if len(line) == 0 {
+// interpolateEscaped expands the variable references in s, keeping flag
+// references and escaping every other "$" as "$$".
+func (f *FlagSet) interpolateEscaped(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
 			continue
 		}
-
-		// Ignore comments
-		if line[:1] == "#" || line == "---" {
+		if i+1 < len(s) && s[i+1] == '{' {
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", errors.New("unterminated variable reference")
+			}
+			value, err := f.expandVariable(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i = end
 			continue
 		}
+		if i+1 < len(s) && s[i+1] == '$' {
+			i++
+		}
+		b.WriteString("$$")
+	}
+	return b.String(), nil
+}
 
-		// Match `key=value` and `key value`
-		var name, value string
-		hasValue := false
-		for i, v := range line {
-			if v == '=' || v == ' ' || v == ':' {
-				hasValue = true
-				name, value = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+// expandVariable returns the value of the reference `${ref}`, with "$"
+// escaped as "$$", see interpolateEscaped.
+func (f *FlagSet) expandVariable(ref string) (string, error) {
+	if strings.HasPrefix(ref, flagReferencePrefix) {
+		// resolved after parsing, see resolveFlagReferences
+		return "${" + ref + "}", nil
+	}
 
-				// check if the name is an env name
-				for srcName := range f.formal {
-					if flagNameToEnvKey(srcName, f.envPrefix) == name {
-						name = srcName
-						break
-					}
-				}
-				break
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", errors.New("empty variable name in reference")
+	}
+
+	value, ok := f.lookupEnv(name)
+	if hasDefault && value == "" {
+		return f.interpolateEscaped(def)
+	}
+	if !ok && f.interpolation == InterpolationStrict {
+		return "", fmt.Errorf("undefined variable %q", name)
+	}
+	return strings.ReplaceAll(value, "$", "$$"), nil
+}
+
+// closingBrace returns the index of the "}" closing the reference started
+// before start in s, or -1. References may be nested in defaults.
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
 			}
+			depth--
 		}
+	}
+	return -1
+}
 
-		if !hasValue {
-			name = line
+// flagReferencePrefix starts a reference to a flag, as in `${flag:name}`.
+const flagReferencePrefix = "flag:"
+
+// resolveFlagReferences expands the `${flag:name}` references in the values
+// of the flags holding a string, see SetInterpolation. Referenced flags are
+// expanded first.
+func (f *FlagSet) resolveFlagReferences() error {
+	if f.interpolation == InterpolationOff {
+		return nil
+	}
+
+	const (
+		visiting = 1
+		resolved = 2
+	)
+	state := make(map[string]int)
+	var stack []string
+
+	var resolve func(flag *Flag) error
+	resolve = func(flag *Flag) error {
+		switch state[flag.Name] {
+		case resolved:
+			return nil
+		case visiting:
+			i := 0
+			for stack[i] != flag.Name {
+				i++
+			}
+			return fmt.Errorf("cyclic flag reference: %s -> %s", strings.Join(stack[i:], " -> "), flag.Name)
 		}
 
-		// Ignore flag when already set; arguments have precedence over file
-		if f.actual[name] != nil {
-			continue
+		value, ok := flagReferences(flag)
+		if !ok {
+			state[flag.Name] = resolved
+			return nil
 		}
 
-		m := f.formal
-		flag, alreadythere := m[name]
-		if !alreadythere {
-			if name == "help" || name == "h" { // special case for nice help message.
-				f.usage()
-				return ErrHelp
+		state[flag.Name] = visiting
+		stack = append(stack, flag.Name)
+
+		var b strings.Builder
+		secret := false
+		for i := 0; i < len(value); i++ {
+			if strings.HasPrefix(value[i:], "$$") {
+				b.WriteByte('$')
+				i++
+				continue
+			}
+			end := strings.IndexByte(value[i:], '}')
+			if !strings.HasPrefix(value[i:], "${"+flagReferencePrefix) || end < 0 {
+				b.WriteByte(value[i])
+				continue
+			}
+			end += i
+
+			name := value[i+2+len(flagReferencePrefix) : end]
+			ref := f.formal[name]
+			if ref == nil {
+				return fmt.Errorf("flag %s references undefined flag %q", flag.Name, name)
+			}
+			if err := resolve(ref); err != nil {
+				return err
 			}
-			continue scan // ignore unknown variables in config files
+			secret = secret || f.isSecret(ref)
+
+			b.WriteString(ref.Value.String())
+			i = end
 		}
 
-		if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() { // special case: doesn't need an arg
-			if hasValue {
-				if err := fv.Set(value); err != nil {
-					return f.failf("invalid boolean value %q for configuration variable %s: %v", value, name, err)
-				}
-			} else {
-				// flag without value is regarded a bool
-				fv.Set("true")
+		// the value of a secret flag stays secret in the referencing flag
+		if secret {
+			if f.secrets == nil {
+				f.secrets = make(map[string]bool)
 			}
+			f.secrets[flag.Name] = true
 		} else {
-			if err := flag.Value.Set(value); err != nil {
-				return f.failf("invalid value %q for configuration variable %s: %v", value, name, err)
+			delete(f.secrets, flag.Name)
+		}
+
+		if err := flag.Value.Set(b.String()); err != nil {
+			shown := b.String()
+			if f.isSecret(flag) && shown != "" {
+				shown = redactedValue
+				err = errors.New(strings.ReplaceAll(err.Error(), b.String(), redactedValue))
 			}
+			return fmt.Errorf("invalid value %q for flag %s: %v", shown, flag.Name, err)
 		}
 
-		// update f.actual
-		if f.actual == nil {
-			f.actual = make(map[string]*Flag)
+		stack = stack[:len(stack)-1]
+		state[flag.Name] = resolved
+		return nil
+	}
+
+	for _, flag := range sortFlags(f.formal) {
+		if err := resolve(flag); err != nil {
+			return f.failf("%v", err)
 		}
-		f.actual[name] = flag
 	}
+	return nil
+}
+
+// flagReferences returns the value of flag if it holds a string with
+// references to other flags, or with `$${flag:name}` escaping them.
+func flagReferences(flag *Flag) (string, bool) {
+	value, ok := stringOf(flag)
+	if !ok || !strings.Contains(value, "${"+flagReferencePrefix) {
+		return "", false
+	}
+	return value, true
+}
 
-	if err := scanner.Err(); err != nil {
-		return err
+// stringOf returns the value of flag if it holds a string.
+func stringOf(flag *Flag) (string, bool) {
+	getter, ok := flag.Value.(Getter)
+	if !ok {
+		return "", false
+	}
+	value, ok := getter.Get().(string)
+	return value, ok
+}
+
+// interpolateConfigValue expands the variable references in value of flag
+// read from a config file, if enabled. pos is used as in setConfigValue.
+func (f *FlagSet) interpolateConfigValue(flag *Flag, value, pos string) (string, error) {
+	if f.interpolation == InterpolationOff {
+		return value, nil
 	}
+	expanded, err := f.interpolate(flag, value)
+	if err != nil {
+		if pos != "" {
+			pos = " at " + pos
+		}
+		shown, err := redact(flag, value, err)
+		return "", f.failf("invalid value %q for configuration variable %s%s: %v", shown, flag.Name, pos, err)
+	}
+	return expanded, nil
+}
+
+// Source is a source of flag values, which is applied by [FlagSet.Parse]
+// after the command line. The environment and the config files are
+// sources, see [DefaultSources].
+//
+// Apply sets the flags of f which have not been set yet, by the command line
+// or a preceding source; the first source to set a flag wins.
+// [LookupFunc] and [ListFunc] adapt functions to a Source.
+type Source interface {
+	Apply(f *FlagSet) error
+}
+
+// LookupFunc is a [Source] which looks up the value of each flag not yet set
+// by its name. ok is false if the source has no value for the flag.
+type LookupFunc func(name string) (value string, ok bool, err error)
 
+// Apply implements [Source].
+func (fn LookupFunc) Apply(f *FlagSet) error {
+	for _, flag := range sortFlags(f.formal) {
+		if f.actual[flag.Name] != nil {
+			continue
+		}
+
+		value, ok, err := fn(flag.Name)
+		if err != nil {
+			return f.failf("failed to look up configuration variable %s: %v", flag.Name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := f.setConfigValue(flag, value, "", Origin{Kind: OriginSource, Key: flag.Name}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-type yamlValue struct {
-	Node  *yaml.Node
+// KeyValue is a value listed by a [ListFunc].
+type KeyValue struct {
+	Key   string
 	Value string
-	Error error
 }
 
-var (
-	_ yaml.Unmarshaler = &yamlValue{} // ensure type compatibility
-)
+// ListFunc is a [Source] which lists its values at once. Keys are flag names
+// or their environment variable names; unknown keys are ignored, as in
+// config files.
+type ListFunc func() ([]KeyValue, error)
 
-func (y *yamlValue) UnmarshalYAML(value *yaml.Node) error {
-	y.Node = value
+// Apply implements [Source].
+func (fn ListFunc) Apply(f *FlagSet) error {
+	values, err := fn()
+	if err != nil {
+		return f.failf("failed to list configuration variables: %v", err)
+	}
 
-	if value.Kind != yaml.ScalarNode {
-		y.Error = errors.New("only scalar/single values are supported")
+	for _, kv := range values {
+		flag, err := f.configFlag(kv.Key)
+		if err != nil {
+			return err
+		}
+		if flag == nil {
+			continue
+		}
+
+		if err := f.setConfigValue(flag, kv.Value, "", Origin{Kind: OriginSource, Key: kv.Key}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnvSource returns the [Source] of the environment variables in environ,
+// see [FlagSet.ParseEnv]. A nil environ is the os environment at the time of
+// parsing.
+func EnvSource(environ []string) Source {
+	return envSource{environ}
+}
+
+type envSource struct {
+	environ []string
+}
+
+func (s envSource) Apply(f *FlagSet) error {
+	environ := s.environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	return f.ParseEnv(environ)
+}
+
+// ConfigFileSource returns the [Source] of the config files given by the
+// flag DefaultConfigFlagname, or found in the config search paths if it is
+// empty.
+func ConfigFileSource() Source {
+	return configFileSource{}
+}
+
+type configFileSource struct{}
+
+func (configFileSource) Apply(f *FlagSet) error {
+	if files := f.configFiles(); len(files) > 0 {
+		for _, file := range files {
+			if err := f.parseConfigFile(file); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
+	return f.parseConfigSearchPaths()
+}
 
-	y.Value = value.Value
+// CommandLineSource returns a [Source] which marks the precedence of the
+// command line among the sources. Sources preceding it override the values
+// given on the command line. Without it, the command line precedes all
+// sources.
+//
+// For example, to have the config files override the environment, and both
+// override the command line:
+//
+//	f.SetSources(ConfigFileSource(), EnvSource(nil), CommandLineSource())
+func CommandLineSource() Source {
+	return commandLineSource{}
+}
+
+type commandLineSource struct{}
 
+// Apply marks the flags set on the command line as set again, unless a
+// preceding source has set them.
+func (commandLineSource) Apply(f *FlagSet) error {
+	for name, flag := range f.commandLine {
+		if f.actual[name] == nil {
+			f.actual[name] = flag
+		}
+	}
+	f.commandLine = nil
 	return nil
 }
 
-func (f *FlagSet) parseFile_YAML(path string) error {
-	// open the yaml file
-	fp, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("failed to open file '%s': %v", path, err)
+// overrideCommandLine resets the value of flag, if it has been set on the
+// command line and is about to be set by a source of higher precedence, so
+// that the source replaces the values accumulated by it instead of adding to
+// them.
+func (f *FlagSet) overrideCommandLine(flag *Flag) {
+	if f.commandLine[flag.Name] != nil && f.actual[flag.Name] == nil {
+		if r, ok := flag.Value.(Resetter); ok {
+			r.Reset()
+		}
 	}
-	defer fp.Close()
+}
 
-	// read the root object
-	var values map[string]yamlValue
+// shadowCommandLine lets the sources preceding a CommandLineSource in
+// sources override the flags set on the command line, by unmarking them
+// until the CommandLineSource is applied.
+func (f *FlagSet) shadowCommandLine(sources []Source) {
+	f.commandLine = nil
+	for _, source := range sources {
+		if _, ok := source.(commandLineSource); ok {
+			f.commandLine = f.actual
+			f.actual = make(map[string]*Flag)
+			return
+		}
+	}
+}
 
-	err = yaml.NewDecoder(fp).Decode(&values)
-	if err != nil {
-		return fmt.Errorf("failed to parse file '%s': %v", path, err)
+// DefaultSources returns the sources of a [FlagSet] in their default order:
+// the environment variables before the config files.
+func DefaultSources() []Source {
+	return []Source{EnvSource(nil), ConfigFileSource()}
+}
+
+// SetSources sets the sources applied by Parse after the command line, in
+// order of precedence; see [CommandLineSource] to give sources precedence
+// over the command line. Without sources only the command line is parsed.
+func (f *FlagSet) SetSources(sources ...Source) {
+	f.sources = append([]Source{}, sources...)
+}
+
+// AddSource appends source to the sources of f, with the lowest precedence.
+func (f *FlagSet) AddSource(source Source) {
+	f.SetSources(append(f.configSources(), source)...)
+}
+
+// configSources returns the sources of f.
+func (f *FlagSet) configSources() []Source {
+	if f.sources == nil {
+		return DefaultSources()
 	}
+	return f.sources
+}
 
-	// parse the fields
-scan:
-	for name, value := range values {
+// OriginKind is the kind of source which set the value of a flag.
+type OriginKind int
 
-		// check if the name is an env name
-		for srcName := range f.formal {
-			if flagNameToEnvKey(srcName, f.envPrefix) == name {
-				name = srcName
-				break
-			}
-		}
+// These constants are the kinds of [Origin].
+const (
+	OriginDefault     OriginKind = iota // the flag has not been set
+	OriginCommandLine                   // set by a command line argument
+	OriginSet                           // set by [FlagSet.Set]
+	OriginEnv                           // set by an environment variable
+	OriginFile                          // set by a config file
+	OriginSource                        // set by a [LookupFunc] or [ListFunc]
+)
 
-		// Ignore flag when already set; arguments have precedence over file
-		if f.actual[name] != nil {
-			continue
+func (k OriginKind) String() string {
+	switch k {
+	case OriginDefault:
+		return "default"
+	case OriginCommandLine:
+		return "command line"
+	case OriginSet:
+		return "set"
+	case OriginEnv:
+		return "environment"
+	case OriginFile:
+		return "file"
+	case OriginSource:
+		return "source"
+	}
+	return "OriginKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Origin records where the value of a flag has been set.
+type Origin struct {
+	Kind OriginKind
+	// Key is the name of the environment variable, such as TIMEOUT or
+	// TIMEOUT_FILE, or the key listed by a source.
+	Key string
+	// File is the path of the config file, or of the file named by an
+	// ENVKEY_FILE variable.
+	File string
+	// Line is the line in the config file, 0 if unknown.
+	Line int
+}
+
+func (o Origin) String() string {
+	switch {
+	case o.Kind == OriginEnv && o.File != "":
+		return fmt.Sprintf("environment variable %s (file '%s')", o.Key, o.File)
+	case o.Kind == OriginEnv:
+		return "environment variable " + o.Key
+	case o.Kind == OriginFile && o.Line > 0:
+		return fmt.Sprintf("file '%s' line %d", o.File, o.Line)
+	case o.Kind == OriginFile:
+		return fmt.Sprintf("file '%s'", o.File)
+	case o.Kind == OriginSource && o.Key != "":
+		return "source key " + o.Key
+	}
+	return o.Kind.String()
+}
+
+// setOrigin records the origin of the value of the flag name.
+func (f *FlagSet) setOrigin(name string, origin Origin) {
+	if f.origins == nil {
+		f.origins = make(map[string]Origin)
+	}
+	f.origins[name] = origin
+}
+
+// Origin returns where the value of the flag name has been set. The Kind is
+// OriginDefault if the flag has not been set or is not defined.
+func (f *FlagSet) Origin(name string) Origin {
+	if f.actual[name] == nil {
+		return Origin{}
+	}
+	return f.origins[name]
+}
+
+// VisitOrigin is like [FlagSet.Visit], and passes the origin of the value of
+// each flag to fn.
+func (f *FlagSet) VisitOrigin(fn func(*Flag, Origin)) {
+	f.Visit(func(flag *Flag) {
+		fn(flag, f.Origin(flag.Name))
+	})
+}
+
+// VisitAllOrigin is like [FlagSet.VisitAll], and passes the origin of the
+// value of each flag to fn; it is OriginDefault for flags not set.
+func (f *FlagSet) VisitAllOrigin(fn func(*Flag, Origin)) {
+	f.VisitAll(func(flag *Flag) {
+		fn(flag, f.Origin(flag.Name))
+	})
+}
+
+// errNotScalar is reported for structured configuration values which can not
+// be assigned to a single flag.
+var errNotScalar = errors.New("only scalar/single values are supported")
+
+// configFormatParser returns the parser of the named config file format, or
+// nil if the format is not supported. The names may prefix a path in the
+// config file list, as in "yaml:/run/config/app", to override the detection
+// by suffix.
+func configFormatParser(format string) func(f *FlagSet, r io.Reader, file *configFile) error {
+	switch format {
+	case "text":
+		return (*FlagSet).parseFile_PlainText
+	case "yaml", "yml":
+		return (*FlagSet).parseFile_YAML
+	case "json":
+		return (*FlagSet).parseFile_JSON
+	case "toml":
+		return (*FlagSet).parseFile_TOML
+	case "dotenv", "env":
+		return (*FlagSet).parseFile_Dotenv
+	case "ini", "properties":
+		return (*FlagSet).parseFile_INI
+	}
+	return nil
+}
+
+// configFileFormat returns the format of the config file in path as detected
+// from its suffix. Files with an unknown suffix are plain text.
+func configFileFormat(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml"):
+		return "yaml"
+	case strings.HasSuffix(path, ".json"):
+		return "json"
+	case strings.HasSuffix(path, ".toml"):
+		return "toml"
+	case strings.HasSuffix(path, ".env"):
+		return "dotenv"
+	case strings.HasSuffix(path, ".ini") || strings.HasSuffix(path, ".properties"):
+		return "ini"
+	}
+	return "text"
+}
+
+// splitFormatPrefix splits an explicit format prefix such as "yaml:" off the
+// path. The format is empty if path has no prefix.
+func splitFormatPrefix(path string) (format, rest string) {
+	if i := strings.IndexByte(path, ':'); i > 0 && configFormatParser(path[:i]) != nil {
+		return path[:i], path[i+1:]
+	}
+	return "", path
+}
+
+// DefaultConfigSeparator separates the entries of the config file list
+// unless the flag set uses another separator, see
+// [FlagSet.SetConfigSeparator].
+const DefaultConfigSeparator = ":"
+
+// SetConfigSeparator sets the separator of the entries in the config file
+// list. A separator preceded by a backslash is taken literally, so with the
+// default separator ":" the entry "C\:/app.conf" names the file "C:/app.conf".
+func (f *FlagSet) SetConfigSeparator(sep string) {
+	f.configSeparator = sep
+}
+
+// configFiles returns the entries of the config file list given by the config
+// flag. If the flag holds a list of strings, as defined by
+// [FlagSet.StringList], each element set on the command line or by
+// [FlagSet.Set] is an entry, while the elements set by the environment or
+// another source are split at the config separator like other values.
+// Otherwise its value is split at the config separator.
+func (f *FlagSet) configFiles() []string {
+	cf := f.actual[DefaultConfigFlagname]
+	if cf == nil {
+		cf = f.formal[DefaultConfigFlagname]
+	}
+	if cf == nil {
+		return nil
+	}
+
+	list, isList := listValues(cf)
+	cFile := cf.Value.String()
+	origin := f.origins[cf.Name]
+
+	// staged values are set after the sources are read, so they are used
+	// here, except when reloading a config flag which is not reloadable
+	if values := f.staged[cf.Name]; len(values) > 0 && (!f.reloading || f.reloadable[cf.Name]) {
+		list = nil
+		for _, v := range values {
+			list = append(list, v.value)
 		}
+		cFile = list[len(list)-1]
+		origin = values[0].origin
+	}
 
-		m := f.formal
-		flag, alreadythere := m[name]
-		if !alreadythere {
-			if name == "help" || name == "h" { // special case for nice help message.
-				f.usage()
-				return ErrHelp
+	sep := f.configSeparator
+	if sep == "" {
+		sep = DefaultConfigSeparator
+	}
+	if isList {
+		// a source sets a list once per value, which may hold several entries
+		switch origin.Kind {
+		case OriginEnv, OriginFile, OriginSource:
+			var files []string
+			for _, entry := range list {
+				files = append(files, splitConfigFiles(entry, sep)...)
 			}
-			continue scan // ignore unknown variables in config files
+			return files
 		}
+		return list
+	}
 
-		// forward error
-		if value.Error != nil {
-			return f.failf("invalid value %q for configuration variable %s at line %v: %v", value.Value, name, value.Node.Line, value.Error)
-		}
+	if cFile == "" {
+		return nil
+	}
+	return splitConfigFiles(cFile, sep)
+}
 
-		// set the flag value
-		if err := flag.Value.Set(value.Value); err != nil {
-			return f.failf("invalid value %q for configuration variable %s: %v", value.Value, name, err)
+// splitConfigFiles splits the list of config files at sep, unless it is
+// escaped by a backslash. A format prefix such as "yaml:" stays attached to
+// the path it belongs to when the separator is a colon.
+func splitConfigFiles(cFile, sep string) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(cFile); {
+		switch {
+		case strings.HasPrefix(cFile[i:], "\\"+sep):
+			b.WriteString(sep)
+			i += 1 + len(sep)
+		case strings.HasPrefix(cFile[i:], sep):
+			parts = append(parts, b.String())
+			b.Reset()
+			i += len(sep)
+		default:
+			b.WriteByte(cFile[i])
+			i++
 		}
+	}
+	parts = append(parts, b.String())
 
-		// update f.actual
-		if f.actual == nil {
-			f.actual = make(map[string]*Flag)
+	if sep != ":" {
+		return parts
+	}
+
+	var files []string
+	for i := 0; i < len(parts); i++ {
+		if configFormatParser(strings.TrimPrefix(parts[i], "?")) != nil && i+1 < len(parts) {
+			files = append(files, parts[i]+":"+parts[i+1])
+			i++
+			continue
 		}
-		f.actual[name] = flag
+		files = append(files, parts[i])
 	}
+	return files
+}
+
+// -- stringList Value
+type stringListValue struct {
+	p       *[]string
+	def     []string
+	changed bool
+}
+
+func newStringListValue(val []string, p *[]string) *stringListValue {
+	*p = val
+	return &stringListValue{p: p, def: val}
+}
 
+// Set appends val to the list. The first call replaces the default value.
+func (s *stringListValue) Set(val string) error {
+	if !s.changed {
+		*s.p = nil
+		s.changed = true
+	}
+	*s.p = append(*s.p, val)
 	return nil
 }
+
+func (s *stringListValue) Get() any { return *s.p }
+
+// Reset restores the default value, which the next call to Set replaces.
+func (s *stringListValue) Reset() {
+	*s.p = append([]string(nil), s.def...)
+	s.changed = false
+}
+
+func (s *stringListValue) String() string {
+	if s.p == nil {
+		return ""
+	}
+	return strings.Join(*s.p, ",")
+}
+
+// StringListVar defines a repeatable string flag with specified name, default
+// value, and usage string. The argument p points to a []string variable in
+// which to store the value of the flag. Every occurrence of the flag appends
+// its value to the list, replacing the default value on the first one. Used
+// as the config flag, each occurrence on the command line adds one entry to
+// the config file list.
+func (f *FlagSet) StringListVar(p *[]string, name string, value []string, usage string) {
+	f.Var(newStringListValue(value, p), name, usage)
+}
+
+// StringListVar defines a repeatable string flag with specified name, default
+// value, and usage string. The argument p points to a []string variable in
+// which to store the value of the flag. Every occurrence of the flag appends
+// its value to the list, replacing the default value on the first one.
+func StringListVar(p *[]string, name string, value []string, usage string) {
+	CommandLine.Var(newStringListValue(value, p), name, usage)
+}
+
+// StringList defines a repeatable string flag with specified name, default
+// value, and usage string. The return value is the address of a []string
+// variable that stores the value of the flag. Every occurrence of the flag
+// appends its value to the list, replacing the default value on the first one.
+func (f *FlagSet) StringList(name string, value []string, usage string) *[]string {
+	p := new([]string)
+	f.StringListVar(p, name, value, usage)
+	return p
+}
+
+// StringList defines a repeatable string flag with specified name, default
+// value, and usage string. The return value is the address of a []string
+// variable that stores the value of the flag. Every occurrence of the flag
+// appends its value to the list, replacing the default value on the first one.
+func StringList(name string, value []string, usage string) *[]string {
+	return CommandLine.StringList(name, value, usage)
+}
+
+// configFlag resolves a configuration key, which is either a flag name or its
+// environment variable equivalent, to the registered flag. It returns nil for
+// unknown keys, which are ignored in config files, and for flags which have
+// already been set, as arguments have precedence over files.
+func (f *FlagSet) configFlag(name string) (*Flag, error) {
+	name = f.configFlagName(name)
+
+	// Ignore flag when already set; arguments have precedence over file
+	if f.actual[name] != nil {
+		return nil, nil
+	}
+
+	flag, alreadythere := f.formal[name]
+	if !alreadythere {
+		if name == "help" || name == "h" { // special case for nice help message.
+			f.usage()
+			return nil, ErrHelp
+		}
+		return nil, nil // ignore unknown variables in config files
+	}
+	return flag, nil
+}
+
+// configFlagName returns the name of the flag whose environment variable
+// name is name, or name itself.
+func (f *FlagSet) configFlagName(name string) string {
+	for srcName := range f.formal {
+		if flagNameToEnvKey(srcName, f.envPrefix) == name {
+			return srcName
+		}
+	}
+	return name
+}
+
+// setConfigValue sets flag to the value read from a config file and marks it
+// as set from origin. pos describes the location of the value for error
+// messages and may be empty.
+func (f *FlagSet) setConfigValue(flag *Flag, value, pos string, origin Origin) error {
+	if f.staged != nil {
+		f.staged[flag.Name] = append(f.staged[flag.Name], stagedValue{value, pos, origin})
+		f.actual[flag.Name] = flag
+		return nil
+	}
+
+	f.overrideCommandLine(flag)
+	if err := f.setValue(flag, value, pos, origin); err != nil {
+		return err
+	}
+
+	// update f.actual
+	if f.actual == nil {
+		f.actual = make(map[string]*Flag)
+	}
+	f.actual[flag.Name] = flag
+	f.setOrigin(flag.Name, origin)
+	return nil
+}
+
+// setValue calls the Set method of flag with value read from origin, and
+// reports its error.
+func (f *FlagSet) setValue(flag *Flag, value, pos string, origin Origin) error {
+	if pos != "" {
+		pos = " at " + pos
+	}
+	variable := "configuration variable"
+	if origin.Kind == OriginEnv {
+		variable = "environment variable" // when staged
+	}
+
+	if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() {
+		if err := fv.Set(value); err != nil {
+			shown, err := redact(flag, value, err)
+			return f.failf("invalid boolean value %q for %s %s%s: %v", shown, variable, flag.Name, pos, err)
+		}
+	} else {
+		if err := flag.Value.Set(value); err != nil {
+			shown, err := redact(flag, value, err)
+			return f.failf("invalid value %q for %s %s%s: %v", shown, variable, flag.Name, pos, err)
+		}
+	}
+	return nil
+}
+
+// ParseFile parses flags from the file in path.
+//
+// The format of the file is detected from its suffix:
+// YAML (.yaml, .yml) files will be loaded as actual YAML,
+// JSON (.json) files as a JSON object,
+// TOML (.toml) files as actual TOML,
+// dotenv (.env) files as shell-style KEY=value assignments and
+// INI (.ini) or Java properties (.properties) files with their sections as
+// prefixes of the flag names. Any other file is loaded as plain text.
+//
+// A format prefix such as "yaml:" or "dotenv:" in front of the path overrides
+// the detection; see [FlagSet.ParseFileAs] for the format names.
+func (f *FlagSet) ParseFile(path string) error {
+	format, path := splitFormatPrefix(path)
+	return f.ParseFileAs(path, format)
+}
+
+// ParseFileAs parses flags from the file in path in the given format, which is
+// one of "text", "yaml", "json", "toml", "dotenv" or "ini". The aliases "yml",
+// "env" and "properties" are accepted as well. If format is empty, it is
+// detected from the suffix of path as described for [FlagSet.ParseFile].
+func (f *FlagSet) ParseFileAs(path, format string) error {
+	return f.parseConfig(&configFile{fsys: osFS{}, path: path}, format)
+}
+
+// ParseFS parses flags from the file in path of the file system fsys. The
+// format is selected like for [FlagSet.ParseFile], by an optional format prefix
+// or the suffix of path. This allows to ship default configurations in an
+// [embed.FS].
+func (f *FlagSet) ParseFS(fsys fs.FS, path string) error {
+	format, path := splitFormatPrefix(path)
+	return f.parseConfig(&configFile{fsys: fsys, path: path}, format)
+}
+
+// ParseReader parses flags from the configuration read from r in the given
+// format, see [FlagSet.ParseFileAs] for the format names. If format is empty,
+// the configuration is parsed as plain text. Included files are resolved
+// relative to the working directory.
+func (f *FlagSet) ParseReader(r io.Reader, format string) error {
+	if format == "" {
+		format = "text"
+	}
+	parse, err := configParser("", format)
+	if err != nil {
+		return err
+	}
+	return parse(f, r, &configFile{fsys: osFS{}, path: "<reader>"})
+}
+
+// configParser returns the parser for the config file format. If format is
+// empty, it is detected from the suffix of path.
+func configParser(path, format string) (func(*FlagSet, io.Reader, *configFile) error, error) {
+	if format == "" {
+		format = configFileFormat(path)
+	}
+
+	parse := configFormatParser(format)
+	if parse == nil {
+		return nil, fmt.Errorf("unknown format %q for config file '%s'", format, path)
+	}
+	return parse, nil
+}
+
+// configFile is a config file being parsed.
+type configFile struct {
+	fsys   fs.FS       // file system the file is read from
+	path   string      // path of the file in fsys
+	parent *configFile // file which included this file, nil if not included
+}
+
+// resolve returns the path of the file name included by file. Relative names
+// are resolved against the directory of file.
+func (file *configFile) resolve(name string) string {
+	if _, ok := file.fsys.(osFS); ok {
+		if filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(filepath.Dir(file.path), name)
+	}
+	return path.Join(path.Dir(file.path), name)
+}
+
+// origin returns the origin of a value at line of the file, 0 if unknown.
+func (file *configFile) origin(line int) Origin {
+	return Origin{Kind: OriginFile, File: file.path, Line: line}
+}
+
+// checkCycle returns an error if file includes itself, directly or through
+// other files.
+func (file *configFile) checkCycle() error {
+	for p := file.parent; p != nil; p = p.parent {
+		if filepath.Clean(p.path) != filepath.Clean(file.path) {
+			continue
+		}
+		chain := file.path
+		for c := file.parent; c != p.parent; c = c.parent {
+			chain = c.path + " -> " + chain
+		}
+		return fmt.Errorf("include cycle in config file '%s': %s", file.path, chain)
+	}
+	return nil
+}
+
+// parseConfig opens file and parses it in format. If format is empty, it is
+// detected from the suffix of the path.
+func (f *FlagSet) parseConfig(file *configFile, format string) error {
+	parse, err := configParser(file.path, format)
+	if err != nil {
+		return err
+	}
+	if err := file.checkCycle(); err != nil {
+		return err
+	}
+
+	fp, err := file.fsys.Open(file.path)
+	if err != nil {
+		return fmt.Errorf("failed to open file '%s': %v", file.path, err)
+	}
+	defer fp.Close()
+
+	f.configRead = append(f.configRead, file)
+	return parse(f, fp, file)
+}
+
+// SetConfigFS sets the file system from which the files listed in the config
+// flag are read. If fsys is nil, which is the default, they are read from the
+// operating system's file system.
+func (f *FlagSet) SetConfigFS(fsys fs.FS) {
+	f.configFS = fsys
+}
+
+// configFileSystem returns the file system the config files are read from.
+func (f *FlagSet) configFileSystem() fs.FS {
+	if f.configFS == nil {
+		return osFS{}
+	}
+	return f.configFS
+}
+
+// SetConfigSearchPaths sets the config files which are loaded when the config
+// flag is not defined or empty. Every entry which exists is loaded like an
+// entry of the config file list, earlier entries taking precedence over later
+// ones; missing entries are skipped silently. Environment variables and a
+// leading "~" for the home directory are expanded. Entries which refer to an
+// unset variable are skipped, so a program named app might search
+//
+//	f.SetConfigSearchPaths("$XDG_CONFIG_HOME/app/config.yaml", "~/.config/app", "/etc/app/")
+func (f *FlagSet) SetConfigSearchPaths(paths ...string) {
+	f.configSearchPaths = paths
+}
+
+// parseConfigSearchPaths parses flags from the config search paths which
+// exist.
+func (f *FlagSet) parseConfigSearchPaths() error {
+	fsys := f.configFileSystem()
+	for _, entry := range f.configSearchPaths {
+		format, name := splitFormatPrefix(strings.TrimPrefix(entry, "?"))
+		name, ok := expandSearchPath(name)
+		if !ok {
+			continue
+		}
+		if format != "" {
+			name = format + ":" + name
+		}
+		if err := f.parseConfigEntry(fsys, "?"+name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandSearchPath replaces environment variables and a leading "~" in the
+// search path name. It reports false if name refers to an unset or empty
+// variable or if the home directory is unknown.
+func expandSearchPath(name string) (string, bool) {
+	ok := true
+	name = os.Expand(name, func(key string) string {
+		value := os.Getenv(key)
+		if value == "" {
+			ok = false
+		}
+		return value
+	})
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		name = home + name[1:]
+	}
+	return name, ok
+}
+
+// parseConfigFile parses flags from an entry of the config file list, which
+// may carry a format prefix. The entry is read from the config file system
+// of the flag set and may name a file, a directory or a glob pattern.
+// Directories and patterns load their files in lexical order. An entry with
+// a leading "?", as in "?local.yaml", is optional and skipped if it does not
+// exist; errors reading or parsing it are still reported. The entry "-"
+// reads standard input, see parseStdin.
+func (f *FlagSet) parseConfigFile(entry string) error {
+	return f.parseConfigEntry(f.configFileSystem(), entry, nil)
+}
+
+// includeConfigFile parses flags from the files included by file. The entry
+// is resolved relative to file and handled like an entry of the config file
+// list.
+func (f *FlagSet) includeConfigFile(file *configFile, entry string) error {
+	return f.parseConfigEntry(file.fsys, entry, file)
+}
+
+// parseConfigEntry parses flags from the files referred to by entry, see
+// parseConfigFile. Relative entries included by parent are resolved against
+// its directory.
+func (f *FlagSet) parseConfigEntry(fsys fs.FS, entry string, parent *configFile) error {
+	optional := strings.HasPrefix(entry, "?")
+	format, name := splitFormatPrefix(strings.TrimPrefix(entry, "?"))
+	if parent != nil {
+		name = parent.resolve(name)
+	} else if name == "-" {
+		return f.parseStdin(format)
+	}
+
+	paths, err := expandConfigEntry(fsys, name, optional, format != "")
+	if err != nil {
+		return err
+	}
+	f.configExpanded = append(f.configExpanded, configExpansion{fsys, name, optional, format != "", paths})
+
+	for _, path := range paths {
+		if err := f.parseConfig(&configFile{fsys: fsys, path: path, parent: parent}, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStdin parses flags from standard input, which is read at most once per
+// parse. If format is empty, it is detected from the content, see
+// detectConfigFormat.
+func (f *FlagSet) parseStdin(format string) error {
+	if f.reloading {
+		return nil // standard input can not be read again
+	}
+	if f.stdinRead {
+		return errors.New("failed to open file '-': standard input has already been read")
+	}
+	f.stdinRead = true
+
+	stdin := f.stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read file '-': %v", err)
+	}
+
+	if format == "" {
+		format = detectConfigFormat(data)
+	}
+	parse, err := configParser("-", format)
+	if err != nil {
+		return err
+	}
+	return parse(f, bytes.NewReader(data), &configFile{fsys: osFS{}, path: "-"})
+}
+
+// detectConfigFormat guesses the format of the configuration in data. An
+// object is JSON; otherwise the first line which is neither empty nor a
+// comment decides: a `[section]` is INI, a line starting with "---", "- "
+// or a key followed by ":" and a space or the end of the line is YAML, an
+// `export` or a key directly followed by "=" is dotenv, and a key followed
+// by spaces and "=" is INI. Anything else, including `key:value`, is plain
+// text.
+func detectConfigFormat(data []byte) string {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return "json"
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		switch {
+		case line[0] == '[' && line[len(line)-1] == ']':
+			return "ini"
+		case line == "---" || strings.HasPrefix(line, "- "):
+			return "yaml"
+		case strings.HasPrefix(line, "export "):
+			return "dotenv"
+		}
+
+		i := strings.IndexAny(line, "=: \t")
+		if i < 0 {
+			return "text"
+		}
+		switch rest := strings.TrimLeft(line[i:], " \t"); {
+		case line[i] == ':' && (i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t'):
+			return "yaml"
+		case line[i] == '=':
+			return "dotenv"
+		case rest != "" && rest[0] == '=':
+			return "ini"
+		}
+		return "text"
+	}
+	return "text"
+}
+
+// configExpansion is an entry of the config file list and the files it
+// referred to when it was parsed.
+type configExpansion struct {
+	fsys      fs.FS
+	name      string
+	optional  bool
+	anySuffix bool
+	paths     []string
+}
+
+// changed reports whether the entry refers to other files now, such as a new
+// file in a directory or an optional file which has been created.
+func (e configExpansion) changed() bool {
+	paths, err := expandConfigEntry(e.fsys, e.name, e.optional, e.anySuffix)
+	return err != nil || !slices.Equal(paths, e.paths)
+}
+
+// expandConfigEntry returns the files referred to by the entry name, see
+// expandConfigPath. An optional entry which does not exist refers to no
+// file.
+func expandConfigEntry(fsys fs.FS, name string, optional, anySuffix bool) ([]string, error) {
+	if optional && !strings.ContainsAny(name, "*?[") {
+		if _, err := fs.Stat(fsys, name); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	return expandConfigPath(fsys, name, anySuffix)
+}
+
+// expandConfigPath returns the files referred to by name. Glob patterns are
+// replaced by the files matching them. Directories are replaced by the files
+// they contain which have a supported suffix, or by all their files if
+// anySuffix is set. Other names, including missing files, are returned as is.
+func expandConfigPath(fsys fs.FS, name string, anySuffix bool) ([]string, error) {
+	join := path.Join
+	if _, ok := fsys.(osFS); ok {
+		join = filepath.Join
+	}
+
+	if strings.ContainsAny(name, "*?[") {
+		matches, err := fs.Glob(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s' in config file list: %v", name, err)
+		}
+		sort.Strings(matches)
+
+		var files []string
+		for _, match := range matches {
+			if info, err := fs.Stat(fsys, match); err == nil && !info.IsDir() {
+				files = append(files, match)
+			}
+		}
+		return files, nil
+	}
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil || !info.IsDir() {
+		return []string{name}, nil
+	}
+
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory '%s': %v", name, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !(anySuffix || isConfigFile(entry.Name())) {
+			continue
+		}
+		files = append(files, join(name, entry.Name()))
+	}
+	return files, nil
+}
+
+// isConfigFile reports whether the file name has the suffix of a supported
+// config file format. Plain text files are recognized by a ".conf" suffix.
+func isConfigFile(name string) bool {
+	return strings.HasSuffix(name, ".conf") || configFileFormat(name) != "text"
+}
+
+// osFS gives access to the operating system's file system through the fs
+// interfaces. Unlike [os.DirFS] it accepts every path the os package does,
+// including absolute paths and paths relative to the working directory.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+// parseFile_PlainText parses flags from the file read from r.
+// Same format as commandline argumens, newlines and lines beginning with a
+// "#" charater are ignored. Flags already set will be ignored.
+//
+// The line `include path` parses the config files in path, relative to the
+// including file, at this point; it accepts the same entries as the config
+// file list. Lines repeating a list flag, such as a [FlagSet.StringList],
+// add to the values set by the file, one per line.
+func (f *FlagSet) parseFile_PlainText(r io.Reader, file *configFile) error {
+
+	// Extract arguments from file
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	// list flags set by the file, which may be repeated
+	lists := make(map[string]*Flag)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNo++
+
+		// Ignore empty lines
+		if len(line) == 0 {
+			continue
+		}
+
+		// Ignore comments
+		if line[:1] == "#" || line == "---" {
+			continue
+		}
+
+		// Match `key=value` and `key value`
+		var name, value string
+		hasValue := false
+		for i, v := range line {
+			if v == '=' || v == ' ' || v == ':' {
+				hasValue = true
+				name, value = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+				break
+			}
+		}
+
+		if !hasValue {
+			name = line
+		}
+
+		// Include other config files
+		if name == "include" && hasValue {
+			if err := f.includeConfigFile(file, value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var err error
+		flag := lists[f.configFlagName(name)]
+		if flag == nil {
+			if flag, err = f.configFlag(name); err != nil {
+				return err
+			}
+			if flag == nil {
+				continue
+			}
+			if _, ok := listValues(flag); ok {
+				lists[flag.Name] = flag
+			}
+		}
+
+		if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() && !hasValue {
+			// flag without value is regarded a bool
+			value = "true"
+		}
+
+		if value, err = f.interpolateConfigValue(flag, value, ""); err != nil {
+			return err
+		}
+		if err := f.setConfigValue(flag, value, "", file.origin(lineNo)); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type yamlValue struct {
+	Node  *yaml.Node
+	Value string
+	Error error
+}
+
+var (
+	_ yaml.Unmarshaler = &yamlValue{} // ensure type compatibility
+)
+
+func (y *yamlValue) UnmarshalYAML(value *yaml.Node) error {
+	y.Node = value
+
+	if value.Kind != yaml.ScalarNode {
+		y.Error = errors.New("only scalar/single values are supported")
+		return nil
+	}
+
+	y.Value = value.Value
+
+	return nil
+}
+
+// parseFile_YAML parses flags from the YAML file read from r.
+// Keys of nested mappings are joined with a "." to the flag name, so
+// `db: {host: x}` sets the flag "db.host". The elements of a sequence are
+// passed one by one to the Set method of the flag, which suits flags that
+// accumulate their values. Flags already set will be ignored.
+//
+// A value tagged `!include path` is replaced by the YAML file in path,
+// relative to the including file; `db: !include db.yaml` nests its keys
+// below "db" and `<<: !include common.yaml` merges them at the top level.
+func (f *FlagSet) parseFile_YAML(r io.Reader, file *configFile) error {
+	root, err := decodeYAML(r, file)
+	if err != nil || root == nil {
+		return err
+	}
+	return f.applyYAML(root, "", file)
+}
+
+// decodeYAML reads the root mapping of the YAML file from r. It returns nil
+// for an empty file.
+func decodeYAML(r io.Reader, file *configFile) (*yaml.Node, error) {
+	var doc yaml.Node
+
+	err := yaml.NewDecoder(r).Decode(&doc)
+	if err == io.EOF {
+		return nil, nil // empty file
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file '%s': %v", file.path, err)
+	}
+
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to parse file '%s': line %v: expected a mapping at the top level", file.path, root.Line)
+	}
+	return root, nil
+}
+
+// applyYAML sets the flags from the YAML mapping root of file, with prefix
+// joined to its keys.
+func (f *FlagSet) applyYAML(root *yaml.Node, prefix string, file *configFile) error {
+	return walkYAML(root, prefix, func(name string, node *yaml.Node) error {
+		if node.Tag == "!include" {
+			return f.includeYAML(file, node, name)
+		}
+
+		flag, err := f.configFlag(name)
+		if err != nil {
+			return err
+		}
+		if flag == nil {
+			return nil
+		}
+
+		// sequences call Set once per element, for repeatable flags
+		elements := []*yaml.Node{node}
+		if node.Kind == yaml.AliasNode && node.Alias.Kind == yaml.SequenceNode {
+			node = node.Alias
+		}
+		if node.Kind == yaml.SequenceNode {
+			elements = node.Content
+		}
+
+		for _, element := range elements {
+			var value yamlValue
+			if err := element.Decode(&value); err != nil {
+				return fmt.Errorf("failed to parse file '%s': %v", file.path, err)
+			}
+
+			// forward error
+			if value.Error != nil {
+				shown, err := redact(flag, value.Value, value.Error)
+				return f.failf("invalid value %q for configuration variable %s at line %v: %v", shown, flag.Name, value.Node.Line, err)
+			}
+
+			// set the flag value
+			pos := fmt.Sprintf("line %v", element.Line)
+			expanded, err := f.interpolateConfigValue(flag, value.Value, pos)
+			if err != nil {
+				return err
+			}
+			if err := f.setConfigValue(flag, expanded, pos, file.origin(element.Line)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// includeYAML sets the flags from the YAML file included by the `!include`
+// node of file, with prefix joined to its keys.
+func (f *FlagSet) includeYAML(file *configFile, node *yaml.Node, prefix string) error {
+	format, name := splitFormatPrefix(node.Value)
+	if format == "" {
+		format = configFileFormat(name)
+	}
+	if format != "yaml" && format != "yml" {
+		return fmt.Errorf("failed to parse file '%s': line %v: !include of '%s' which is not a YAML file", file.path, node.Line, node.Value)
+	}
+
+	included := &configFile{fsys: file.fsys, path: file.resolve(name), parent: file}
+	if err := included.checkCycle(); err != nil {
+		return err
+	}
+
+	fp, err := included.fsys.Open(included.path)
+	if err != nil {
+		return fmt.Errorf("failed to open file '%s': %v", included.path, err)
+	}
+	defer fp.Close()
+
+	root, err := decodeYAML(fp, included)
+	if err != nil || root == nil {
+		return err
+	}
+	return f.applyYAML(root, prefix, included)
+}
+
+// walkYAML calls fn in document order for every value of the mapping node
+// which is not a mapping itself. Keys of nested mappings are joined with a
+// "." to the name passed to fn. Merged mappings (`<<: *anchor`) are walked
+// after the keys of the mapping, skipping the keys it defines, as explicit
+// keys override merged ones.
+func walkYAML(node *yaml.Node, prefix string, fn func(name string, node *yaml.Node) error) error {
+	return walkYAMLMapping(node, prefix, nil, fn)
+}
+
+// walkYAMLMapping is walkYAML skipping the keys in exclude.
+func walkYAMLMapping(node *yaml.Node, prefix string, exclude map[string]bool, fn func(name string, node *yaml.Node) error) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	defined := make(map[string]bool)
+	for key := range exclude {
+		defined[key] = true
+	}
+	var merges []*yaml.Node
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind == yaml.AliasNode && value.Alias.Kind == yaml.MappingNode {
+			value = value.Alias
+		}
+
+		// merge keys (`<<: *anchor`) inline the referenced mapping
+		if key.Tag == "!!merge" {
+			merges = append(merges, value)
+			continue
+		}
+		if exclude[key.Value] {
+			continue
+		}
+		defined[key.Value] = true
+
+		name := key.Value
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		var err error
+		if value.Kind == yaml.MappingNode {
+			err = walkYAML(value, name, fn)
+		} else {
+			err = fn(name, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, value := range merges {
+		if value.Tag == "!include" {
+			if err := fn(prefix, value); err != nil {
+				return err
+			}
+			continue
+		}
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, m := range merged {
+			if err := walkYAMLMapping(m, prefix, defined, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseFile_JSON parses flags from the JSON file read from r.
+// The file holds a single object whose keys are flag names or their
+// environment variable equivalents. Flags already set will be ignored.
+func (f *FlagSet) parseFile_JSON(r io.Reader, file *configFile) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read file '%s': %v", file.path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	// syntaxError annotates decoding errors with their location
+	syntaxError := func(err error) error {
+		pos, _ := jsonPos(data, dec.InputOffset())
+		var se *json.SyntaxError
+		if errors.As(err, &se) && se.Offset > 0 {
+			pos = lineColumn(data, se.Offset-1)
+		}
+		return fmt.Errorf("failed to parse file '%s': %s: %v", file.path, pos, err)
+	}
+
+	// read the root object
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil // empty file
+	}
+	if err != nil {
+		return syntaxError(err)
+	}
+	if tok != json.Delim('{') {
+		return syntaxError(errors.New("expected an object"))
+	}
+
+	// parse the fields
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return syntaxError(err)
+		}
+		name, _ := tok.(string)
+		offset := dec.InputOffset()
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return syntaxError(err)
+		}
+
+		flag, err := f.configFlag(name)
+		if err != nil {
+			return err
+		}
+		if flag == nil {
+			continue
+		}
+
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return syntaxError(err)
+		}
+		pos, line := jsonPos(data, offset)
+
+		switch v := value.(type) {
+		case nil:
+			continue // null leaves the flag unset
+		case string:
+			err = f.setConfigValue(flag, v, pos, file.origin(line))
+		case bool, float64:
+			err = f.setConfigValue(flag, string(raw), pos, file.origin(line))
+		default:
+			shown, _ := redact(flag, string(raw), nil)
+			err = f.failf("invalid value %s for configuration variable %s at %s: %v", shown, flag.Name, pos, errNotScalar)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return syntaxError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return syntaxError(errors.New("unexpected data after top-level object"))
+	}
+
+	return nil
+}
+
+// parseFile_Dotenv parses flags from the dotenv file read from r.
+// Each line holds a `KEY=value` assignment which may be preceded by `export`.
+// Values may be enclosed in single quotes, which are taken literally, or in
+// double quotes, which support backslash escapes. Quoted values may span
+// multiple lines. Unquoted values end at a " #" comment. Flags already set
+// will be ignored.
+func (f *FlagSet) parseFile_Dotenv(r io.Reader, file *configFile) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read file '%s': %v", file.path, err)
+	}
+
+	entries, err := parseDotenv(string(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse file '%s': %v", file.path, err)
+	}
+
+	for _, entry := range entries {
+		flag, err := f.configFlag(entry.key)
+		if err != nil {
+			return err
+		}
+		if flag == nil {
+			continue
+		}
+
+		if err := f.setConfigValue(flag, entry.value, fmt.Sprintf("line %d", entry.line), file.origin(entry.line)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dotenvEntry is a single assignment of a dotenv file.
+type dotenvEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+// parseDotenv splits the content of a dotenv file into its assignments.
+func parseDotenv(data string) ([]dotenvEntry, error) {
+	var entries []dotenvEntry
+
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t")
+		lineNo := i + 1
+
+		// Ignore empty lines and comments
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+
+		if rest := strings.TrimPrefix(line, "export"); rest != line && len(rest) > 0 && (rest[0] == ' ' || rest[0] == '\t') {
+			line = strings.TrimLeft(rest, " \t")
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 1 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		key, value := strings.TrimSpace(line[:eq]), strings.TrimLeft(line[eq+1:], " \t")
+
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			// unquoted values end at a comment
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = value[:j]
+			}
+			if j := strings.Index(value, "\t#"); j >= 0 {
+				value = value[:j]
+			}
+			entries = append(entries, dotenvEntry{key, strings.TrimSpace(value), lineNo})
+			continue
+		}
+
+		// quoted values continue until the closing quote
+		quote := value[0]
+		raw := value[1:]
+		var b strings.Builder
+		for {
+			j := dotenvClosingQuote(raw, quote)
+			if j >= 0 {
+				b.WriteString(raw[:j])
+				if rest := strings.TrimSpace(raw[j+1:]); rest != "" && rest[0] != '#' {
+					return nil, fmt.Errorf("line %d: unexpected %q after quoted value", i+1, rest)
+				}
+				break
+			}
+			if i+1 >= len(lines) {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", lineNo)
+			}
+			b.WriteString(raw)
+			b.WriteByte('\n')
+			i++
+			raw = lines[i]
+		}
+
+		value = b.String()
+		if quote == '"' {
+			value = dotenvUnescape(value)
+		}
+		entries = append(entries, dotenvEntry{key, value, lineNo})
+	}
+
+	return entries, nil
+}
+
+// dotenvClosingQuote returns the index of the closing quote in s, or -1.
+// Backslashes escape the following character in double quoted values.
+func dotenvClosingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && quote == '"' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+// dotenvUnescape replaces the backslash escapes of a double quoted value.
+// A backslash at the end of a line joins it with the next one.
+func dotenvUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\n':
+			// line continuation
+		case '"', '\\', '$', '`':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// stripINIComment removes a trailing comment from the INI value, which
+// starts with a ";" preceded by a space or tab. A quoted value may contain
+// comment characters.
+func stripINIComment(value string) string {
+	start := 0
+	if strings.HasPrefix(value, "\"") {
+		if end := strings.IndexByte(value[1:], '"'); end >= 0 {
+			start = end + 2
+		}
+	}
+	for i := max(start, 1); i < len(value); i++ {
+		if value[i] == ';' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return value
+}
+
+// parseFile_INI parses flags from the INI or properties file read from r.
+// Keys are separated from their values by "=" or ":". The key in a
+// `[section]` is joined with a "." to the flag name, so `host` in the
+// section `[database]` sets the flag "database.host"; `HOST` in `[DATABASE]`
+// matches the environment variable name DATABASE_HOST. Lines beginning with
+// ";" or "#" are ignored, as is a value's rest from a ";" after a space or
+// tab, and lines ending in a backslash continue on the next line. Flags already set will be ignored.
+func (f *FlagSet) parseFile_INI(r io.Reader, file *configFile) error {
+	scanner := bufio.NewScanner(r)
+
+	var section string
+	var err error
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		start := lineNo
+
+		// Ignore empty lines and comments
+		if len(line) == 0 || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		// Join continuation lines
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			lineNo++
+			line = line[:len(line)-1] + strings.TrimSpace(scanner.Text())
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("failed to parse file '%s': line %d: unterminated section header", file.path, start)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		// Match `key=value` and `key: value`
+		name, value, hasValue := strings.Cut(line, "=")
+		if i := strings.IndexByte(line, ':'); i >= 0 && (!hasValue || i < len(name)) {
+			name, value, hasValue = line[:i], line[i+1:], true
+		}
+		name, value = strings.TrimSpace(name), stripINIComment(strings.TrimSpace(value))
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+
+		var flag *Flag
+		if section == "" {
+			flag, err = f.configFlag(name)
+		} else if flag, err = f.configFlag(section + "." + name); flag == nil && err == nil {
+			flag, err = f.configFlag(section + "_" + name)
+		}
+		if err != nil {
+			return err
+		}
+		if flag == nil {
+			continue
+		}
+
+		if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() && !hasValue {
+			// flag without value is regarded a bool
+			value = "true"
+		}
+
+		if err := f.setConfigValue(flag, value, fmt.Sprintf("line %d", start), file.origin(start)); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseFile_TOML parses flags from the TOML file read from r.
+// Keys of tables are joined with a "." to the flag name, so `host` in the
+// table `[db]` sets the flag "db.host". Arrays set the flag once per
+// element, as for a [FlagSet.StringList] flag. Flags already set will be
+// ignored.
+func (f *FlagSet) parseFile_TOML(r io.Reader, file *configFile) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// read the root table
+	var values map[string]any
+
+	md, err := toml.Decode(string(data), &values)
+	if err != nil {
+		return fmt.Errorf("failed to parse file '%s': %v", file.path, err)
+	}
+
+	// read it again as primitives, which carry the key positions
+	var root map[string]toml.Primitive
+
+	pmd, err := toml.Decode(string(data), &root)
+	if err != nil {
+		return fmt.Errorf("failed to parse file '%s': %v", file.path, err)
+	}
+
+	// parse the fields in the order of the file
+	for _, key := range md.Keys() {
+		value, ok := tomlLookup(values, key)
+		if !ok {
+			continue // part of an array of tables
+		}
+		if _, isTable := value.(map[string]any); isTable {
+			continue // the keys of the table follow
+		}
+
+		flag, err := f.configFlag(strings.Join(key, "."))
+		if err != nil {
+			return err
+		}
+		if flag == nil {
+			continue
+		}
+
+		line := tomlLine(pmd, root, key)
+		pos := fmt.Sprintf("line %d", line)
+
+		// arrays call Set once per element, for repeatable flags
+		elements := []any{value}
+		if array, ok := value.([]any); ok {
+			elements = array
+		}
+		for _, element := range elements {
+			s, ok := tomlScalar(element)
+			if !ok {
+				return f.failf("invalid value for configuration variable %s in file '%s' at %s: %v", flag.Name, file.path, pos, errNotScalar)
+			}
+			if err := f.setConfigValue(flag, s, pos, file.origin(line)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// tomlScalar returns the decoded TOML value v as a string, or false if it is
+// not a scalar.
+func tomlScalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	}
+	return "", false
+}
+
+// tomlLookup returns the value of key in the decoded TOML table.
+func tomlLookup(table map[string]any, key toml.Key) (any, bool) {
+	var value any = table
+	for _, k := range key {
+		t, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = t[k]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// tomlKeyLine fails to decode any TOML value. The decoder reports the
+// position of a key only in the errors it returns, so tomlLine decodes a
+// key into a tomlKeyLine to learn its line.
+type tomlKeyLine struct{}
+
+var errTOMLKeyLine = errors.New("key line")
+
+func (*tomlKeyLine) UnmarshalTOML(any) error { return errTOMLKeyLine }
+
+// tomlLine returns the line of key in the TOML file decoded into root, or 0
+// if the decoder does not know it.
+func tomlLine(md toml.MetaData, root map[string]toml.Primitive, key toml.Key) int {
+	table := root
+	for _, k := range key[:len(key)-1] {
+		prim, ok := table[k]
+		if !ok {
+			return 0
+		}
+		table = nil
+		if err := md.PrimitiveDecode(prim, &table); err != nil {
+			return 0
+		}
+	}
+
+	prim, ok := table[key[len(key)-1]]
+	if !ok {
+		return 0
+	}
+	var perr toml.ParseError
+	if err := md.PrimitiveDecode(prim, &tomlKeyLine{}); !errors.As(err, &perr) {
+		return 0
+	}
+	return perr.Position.Line
+}
+
+// jsonPos returns the line and column of the first token at or after offset
+// in data, skipping whitespace and separators, and the line alone.
+func jsonPos(data []byte, offset int64) (string, int) {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n:,", data[offset]) >= 0 {
+		offset++
+	}
+	return lineColumn(data, offset), 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// lineColumn returns the line and column of offset in data.
+func lineColumn(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+// WriteConfig writes the current value of every flag to w in the given
+// format, which is one of "text", "yaml" or "dotenv" (or their aliases, see
+// [FlagSet.ParseFileAs]). The usage of each flag precedes it as a comment.
+// The config flag and the print-config flag, see [FlagSet.EnablePrintConfig],
+// are not written. Secret flags, see [FlagSet.SecretString], are written in
+// plain text, so the output can be read back with [FlagSet.ParseFileAs] in
+// the same format.
+func (f *FlagSet) WriteConfig(w io.Writer, format string) error {
+	return f.writeConfig(w, format, false)
+}
+
+// WriteChangedConfig is like [FlagSet.WriteConfig], but writes only the flags
+// whose value differs from their default.
+func (f *FlagSet) WriteChangedConfig(w io.Writer, format string) error {
+	return f.writeConfig(w, format, true)
+}
+
+func (f *FlagSet) writeConfig(w io.Writer, format string, changedOnly bool) error {
+	var flags []*Flag
+	f.VisitAll(func(flag *Flag) {
+		if flag.Name == DefaultConfigFlagname || flag.Name == PrintConfigFlagname {
+			return
+		}
+		if changedOnly && flag.Value.String() == flag.DefValue {
+			return
+		}
+		flags = append(flags, flag)
+	})
+
+	// values are read back with interpolation, which needs a "$" escaped
+	escape := func(value string) string { return value }
+	if f.interpolation != InterpolationOff {
+		escape = func(value string) string { return strings.ReplaceAll(value, "$", "$$") }
+	}
+
+	switch format {
+	case "text":
+		return writeConfig_PlainText(w, flags, escape)
+	case "yaml", "yml":
+		return writeConfig_YAML(w, flags, escape)
+	case "dotenv", "env":
+		return writeConfig_Dotenv(w, flags, f.envPrefix)
+	}
+	return fmt.Errorf("unsupported format %q for writing config", format)
+}
+
+// writeConfigComment writes the usage of flag as a comment to b.
+func writeConfigComment(b *strings.Builder, flag *Flag) {
+	_, usage := UnquoteUsage(flag)
+	if usage == "" {
+		return
+	}
+	for _, line := range strings.Split(usage, "\n") {
+		b.WriteString(strings.TrimRight("# "+line, " "))
+		b.WriteByte('\n')
+	}
+}
+
+// listValues returns the values of flag if it holds a list of strings, as
+// [FlagSet.StringList] flags do.
+func listValues(flag *Flag) ([]string, bool) {
+	if getter, ok := flag.Value.(Getter); ok {
+		if list, ok := getter.Get().([]string); ok {
+			return list, true
+		}
+	}
+	return nil, false
+}
+
+// writeConfig_PlainText writes flags as `name value` lines, with one line per
+// element of a list.
+func writeConfig_PlainText(w io.Writer, flags []*Flag, escape func(string) string) error {
+	var b strings.Builder
+	for i, flag := range flags {
+		values, ok := listValues(flag)
+		if !ok {
+			values = []string{flag.Value.String()}
+		}
+
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		writeConfigComment(&b, flag)
+		for _, value := range values {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("value of flag %s spans multiple lines, which plain text does not support", flag.Name)
+			}
+			b.WriteString(flag.Name)
+			b.WriteByte(' ')
+			b.WriteString(escape(value))
+			b.WriteByte('\n')
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeConfig_YAML writes flags as a YAML mapping. Lists of strings, as held
+// by [FlagSet.StringList] flags, are written as sequences.
+func writeConfig_YAML(w io.Writer, flags []*Flag, escape func(string) string) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, flag := range flags {
+		_, usage := UnquoteUsage(flag)
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: flag.Name, HeadComment: usage}
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: escape(flag.Value.String())}
+
+		if list, ok := listValues(flag); ok {
+			value = &yaml.Node{Kind: yaml.SequenceNode}
+			for _, v := range list {
+				value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: escape(v)})
+			}
+		}
+
+		root.Content = append(root.Content, key, value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// dotenvEscaper escapes a value for a double quoted dotenv value.
+var dotenvEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"$", "\\$",
+	"`", "\\`",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+)
+
+// writeConfig_Dotenv writes flags as `KEY="value"` lines, with the names of
+// the environment variables of the flags as keys. Lists of more than one
+// element can not be written, as a key holds a single value.
+func writeConfig_Dotenv(w io.Writer, flags []*Flag, envPrefix string) error {
+	var b strings.Builder
+	for i, flag := range flags {
+		if list, ok := listValues(flag); ok && len(list) > 1 {
+			return fmt.Errorf("value of flag %s is a list, which dotenv does not support", flag.Name)
+		}
+
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		writeConfigComment(&b, flag)
+		fmt.Fprintf(&b, "%s=\"%s\"\n", flagNameToEnvKey(flag.Name, envPrefix), dotenvEscaper.Replace(flag.Value.String()))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// PrintConfigFlagname is the name of the flag defined by
+// [FlagSet.EnablePrintConfig].
+var PrintConfigFlagname = "print-config"
+
+// ErrPrintConfig is the error returned by Parse with ContinueOnError after
+// the configuration has been printed as requested by the flag
+// PrintConfigFlagname.
+var ErrPrintConfig = errors.New("flag: configuration printed")
+
+// printConfigValue is the format requested by the flag PrintConfigFlagname,
+// empty if not requested.
+type printConfigValue string
+
+func (v *printConfigValue) Set(s string) error {
+	switch s {
+	case "true":
+		s = "table"
+	case "false":
+		s = ""
+	case "table", "yaml", "json":
+	default:
+		return fmt.Errorf("unsupported format %q", s)
+	}
+	*v = printConfigValue(s)
+	return nil
+}
+
+func (v *printConfigValue) String() string { return string(*v) }
+
+func (v *printConfigValue) IsBoolFlag() bool { return true }
+
+// EnablePrintConfig defines the flag PrintConfigFlagname. When it is set,
+// Parse prints the configuration with [FlagSet.PrintConfig] to the output of
+// f, after all sources have been applied, and exits; with ContinueOnError it
+// returns ErrPrintConfig instead. `-print-config` prints a table,
+// `-print-config=yaml` and `-print-config=json` select the format.
+func (f *FlagSet) EnablePrintConfig() {
+	f.Var(new(printConfigValue), PrintConfigFlagname, "print the configuration with the source of each value and exit (=yaml or =json for other formats)")
+}
+
+// EnablePrintConfig defines the flag PrintConfigFlagname of the command line,
+// see [FlagSet.EnablePrintConfig].
+func EnablePrintConfig() {
+	CommandLine.EnablePrintConfig()
+}
+
+// printConfigRequested prints the configuration if requested by the flag
+// PrintConfigFlagname, and returns ErrPrintConfig then.
+func (f *FlagSet) printConfigRequested() error {
+	flag := f.actual[PrintConfigFlagname]
+	if flag == nil {
+		return nil
+	}
+	format, ok := flag.Value.(*printConfigValue)
+	if !ok || *format == "" {
+		return nil
+	}
+
+	if err := f.PrintConfig(f.Output(), string(*format)); err != nil {
+		return err
+	}
+	return ErrPrintConfig
+}
+
+// configEntry is a flag as printed by PrintConfig.
+type configEntry struct {
+	Name    string `json:"name" yaml:"name"`
+	Value   string `json:"value" yaml:"value"`
+	Default string `json:"default" yaml:"default"`
+	Source  string `json:"source" yaml:"source"`
+}
+
+// PrintConfig prints the value, the default and the origin of every flag to
+// w, in the format "table", "yaml" or "json". The values of secret flags,
+// and of flags referencing them with ${flag:...}, are masked.
+func (f *FlagSet) PrintConfig(w io.Writer, format string) error {
+	var entries []configEntry
+	f.VisitAllOrigin(func(flag *Flag, origin Origin) {
+		if flag.Name == PrintConfigFlagname {
+			return
+		}
+		value, def := flag.Value.String(), flag.DefValue
+		if f.isSecret(flag) && value != "" {
+			value = redactedValue
+		}
+		def, _ = redact(flag, def, nil)
+		entries = append(entries, configEntry{flag.Name, value, def, origin.String()})
+	})
+
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "FLAG\tVALUE\tDEFAULT\tSOURCE")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Name, e.Value, e.Default, e.Source)
+		}
+		return tw.Flush()
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	return fmt.Errorf("unsupported format %q for printing config", format)
+}
+
+// redactedValue replaces the values of secret flags in messages and dumps.
+const redactedValue = "[redacted]"
+
+// secretFlag is implemented by the values of flags whose value must not be
+// shown in error messages, defaults and dumps of the configuration.
+type secretFlag interface {
+	Value
+	IsSecret() bool
+}
+
+// isSecret reports whether the value of flag must not be shown.
+func isSecret(flag *Flag) bool {
+	fv, ok := flag.Value.(secretFlag)
+	return ok && fv.IsSecret()
+}
+
+// isSecret reports whether the value of flag must not be shown, because the
+// flag is secret or references a secret flag.
+func (f *FlagSet) isSecret(flag *Flag) bool {
+	return isSecret(flag) || f.secrets[flag.Name]
+}
+
+// redact masks value, and its occurrences in err, if flag is secret. Empty
+// values are kept, as they reveal nothing.
+func redact(flag *Flag, value string, err error) (string, error) {
+	if !isSecret(flag) || value == "" {
+		return value, err
+	}
+	if err != nil {
+		err = errors.New(strings.ReplaceAll(err.Error(), value, redactedValue))
+	}
+	return redactedValue, err
+}
+
+// secretStringValue is a string value which is secret.
+type secretStringValue string
+
+func newSecretStringValue(val string, p *string) *secretStringValue {
+	*p = val
+	return (*secretStringValue)(p)
+}
+
+func (s *secretStringValue) Set(val string) error {
+	*s = secretStringValue(val)
+	return nil
+}
+
+func (s *secretStringValue) Get() any { return string(*s) }
+
+func (s *secretStringValue) String() string { return string(*s) }
+
+func (s *secretStringValue) IsSecret() bool { return true }
+
+// SecretStringVar defines a secret string flag with specified name, default
+// value, and usage string. The argument p points to a string variable in
+// which to store the value of the flag. The value is masked in error
+// messages, the defaults printed by PrintDefaults and the configuration
+// printed by PrintConfig, but written in plain text by WriteConfig.
+func (f *FlagSet) SecretStringVar(p *string, name string, value string, usage string) {
+	f.Var(newSecretStringValue(value, p), name, usage)
+}
+
+// SecretStringVar defines a secret string flag with specified name, default
+// value, and usage string. The argument p points to a string variable in
+// which to store the value of the flag.
+func SecretStringVar(p *string, name string, value string, usage string) {
+	CommandLine.SecretStringVar(p, name, value, usage)
+}
+
+// SecretString defines a secret string flag with specified name, default
+// value, and usage string. The return value is the address of a string
+// variable that stores the value of the flag. See [FlagSet.SecretStringVar].
+func (f *FlagSet) SecretString(name string, value string, usage string) *string {
+	p := new(string)
+	f.SecretStringVar(p, name, value, usage)
+	return p
+}
+
+// SecretString defines a secret string flag with specified name, default
+// value, and usage string. The return value is the address of a string
+// variable that stores the value of the flag.
+func SecretString(name string, value string, usage string) *string {
+	return CommandLine.SecretString(name, value, usage)
+}
+
+// stagedValue is a value read from a source while staging.
+type stagedValue struct {
+	value  string
+	pos    string
+	origin Origin
+}
+
+// SetTransactional sets whether Parse stages the values of all sources,
+// validates them and only then sets the flags, so that an invalid value
+// leaves the flags as set by the command line instead of half-updated. It
+// is off by default. The values of the flags defined by this package are
+// validated by setting them on a new value of the same type; values of
+// other flags are validated if they implement [Validator]. Values which can
+// not be validated, such as those of [Func] and [BoolFunc] flags, are set
+// first, once all other values are valid; if one of them fails, the flags
+// set before it keep their values. Reloads are always transactional.
+func (f *FlagSet) SetTransactional(enabled bool) {
+	f.transactional = enabled
+}
+
+// Validator is implemented by values which can check a value without
+// setting it, so that transactional parses and reloads validate it before
+// setting any flag, see [FlagSet.SetTransactional].
+type Validator interface {
+	Validate(value string) error
+}
+
+// stageSources starts staging the values of the sources, if transactional,
+// and returns the flags set before for abortStaging.
+func (f *FlagSet) stageSources() map[string]*Flag {
+	if !f.transactional {
+		return nil
+	}
+	actual := maps.Clone(f.actual)
+	if f.actual == nil {
+		f.actual = make(map[string]*Flag)
+	}
+	f.staged = make(map[string][]stagedValue)
+	return actual
+}
+
+// abortStaging discards the staged values, marking only the flags in actual
+// as set, after a source failed. Without staging, the flags set on the
+// command line are marked as set again.
+func (f *FlagSet) abortStaging(actual map[string]*Flag) {
+	if f.staged != nil {
+		f.staged = nil
+		f.actual = actual
+	} else {
+		for name, flag := range f.commandLine {
+			f.actual[name] = flag
+		}
+	}
+	f.commandLine = nil
+}
+
+// commitStaged validates the staged values and sets the flags to them, or
+// aborts staging if one of them is invalid. If a value which could not be
+// validated fails, the flags set before it stay set.
+func (f *FlagSet) commitStaged(actual map[string]*Flag) error {
+	staged := f.staged
+	if staged == nil {
+		return nil
+	}
+	if err := f.validateStaged(staged); err != nil {
+		f.abortStaging(actual)
+		return err
+	}
+
+	f.staged = nil
+	var committed []*Flag
+	for _, flag := range commitOrder(sortFlags(f.formal)) {
+		values := staged[flag.Name]
+		if len(values) == 0 {
+			continue
+		}
+		// staged values of flags set on the command line override them
+		if r, ok := flag.Value.(Resetter); ok && actual[flag.Name] != nil {
+			r.Reset()
+		}
+		for _, v := range values {
+			if err := f.setConfigValue(flag, v.value, v.pos, v.origin); err != nil {
+				f.actual = actual
+				for _, flag := range committed {
+					f.actual[flag.Name] = flag
+				}
+				return err
+			}
+		}
+		committed = append(committed, flag)
+	}
+	return nil
+}
+
+// validateStaged reports the first staged value which is invalid. Values
+// which can not be validated, see scratchValue, are accepted.
+func (f *FlagSet) validateStaged(staged map[string][]stagedValue) error {
+	for _, flag := range sortFlags(f.formal) {
+		values := staged[flag.Name]
+		if len(values) == 0 {
+			continue
+		}
+		scratch, ok := scratchValue(flag.Value)
+		if !ok {
+			continue
+		}
+
+		probe := *flag
+		probe.Value = scratch
+		for _, v := range values {
+			if err := f.setValue(&probe, v.value, v.pos, v.origin); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commitOrder returns flags with the flags whose values can not be
+// validated first, so that they are set before any validated value.
+func commitOrder(flags []*Flag) []*Flag {
+	var unvalidated, validated []*Flag
+	for _, flag := range flags {
+		if _, ok := scratchValue(flag.Value); ok {
+			validated = append(validated, flag)
+		} else {
+			unvalidated = append(unvalidated, flag)
+		}
+	}
+	return append(unvalidated, validated...)
+}
+
+// scratchValue returns a value whose Set method validates a value for v
+// without affecting v: a new value of the same type for the values defined
+// by this package, or v itself as a Validator. ok is false if there is
+// none, as for Func flags and values defined elsewhere which do not
+// implement Validator.
+func scratchValue(v Value) (scratch Value, ok bool) {
+	switch v := v.(type) {
+	case Validator:
+		return validatingValue{v.(Value), v}, true
+	case *boolValue, *intValue, *int64Value, *uintValue, *uint64Value, *stringValue,
+		*float64Value, *durationValue, *secretStringValue:
+		return reflect.New(reflect.TypeOf(v).Elem()).Interface().(Value), true
+	case *stringListValue:
+		return &stringListValue{p: new([]string)}, true
+	case textValue:
+		// like the encoding packages, unmarshal into a new value
+		p := reflect.New(reflect.TypeOf(v.p).Elem()).Interface()
+		return textValue{p.(encoding.TextUnmarshaler)}, true
+	}
+	return nil, false
+}
+
+// validatingValue is a Value whose Set method only validates the value.
+type validatingValue struct {
+	Value
+	validator Validator
+}
+
+func (v validatingValue) Set(s string) error { return v.validator.Validate(s) }
+
+func (v validatingValue) IsBoolFlag() bool {
+	fv, ok := v.Value.(boolFlag)
+	return ok && fv.IsBoolFlag()
+}
+
+// Resetter is implemented by values which accumulate the values set, such
+// as lists, to restore their default before they are set again by a reload
+// or by a source overriding the command line.
+type Resetter interface {
+	Reset()
+}
+
+// SetReloadable marks the named flags as reloadable: their values may be
+// changed after Parse, when the sources are read again by [FlagSet.Reload]
+// or a [ConfigWatcher]. The flags defined by this package are reset to their
+// default before they are set again; other values must implement
+// [Resetter], as a reload would otherwise add to their values.
+// SetReloadable panics for a defined flag whose value can not be reset.
+func (f *FlagSet) SetReloadable(names ...string) {
+	if f.reloadable == nil {
+		f.reloadable = make(map[string]bool)
+	}
+	for _, name := range names {
+		if flag := f.formal[name]; flag != nil && !canReset(flag.Value) {
+			panic(f.sprintf("flag %s can not be reloaded: its value does not implement Resetter", name))
+		}
+		f.reloadable[name] = true
+	}
+}
+
+// canReset reports whether v can be reset before a reload sets it again:
+// the values of this package are set to their default, other values must
+// implement Resetter.
+func canReset(v Value) bool {
+	switch v.(type) {
+	case Resetter, *boolValue, *intValue, *int64Value, *uintValue, *uint64Value, *stringValue,
+		*float64Value, *durationValue, *secretStringValue, textValue, funcValue, boolFuncValue:
+		return true
+	}
+	return false
+}
+
+// reload reads sources again and applies their values to the reloadable
+// flags which have not been set, or have been set from an origin of one of
+// kinds; the other flags are left unchanged. Values read from standard input
+// are kept, as it can not be read again. reload returns the names of the
+// flags whose value changed.
+func (f *FlagSet) reload(sources []Source, kinds ...OriginKind) ([]string, error) {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	actual := f.actual
+	reloaded := func(name string) bool {
+		if actual[name] == nil {
+			return true
+		}
+		origin := f.origins[name]
+		for _, kind := range kinds {
+			if origin.Kind == kind && origin.File != "-" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// read the sources, with the flags which are kept marked as set
+	configRead, configExpanded := f.configRead, f.configExpanded
+	f.actual = make(map[string]*Flag)
+	for name, flag := range actual {
+		if !reloaded(name) {
+			f.actual[name] = flag
+		}
+	}
+	f.configRead, f.configExpanded = nil, nil
+	f.staged = make(map[string][]stagedValue)
+	f.reloading = true
+
+	var err error
+	for _, source := range sources {
+		if err = source.Apply(f); err != nil {
+			break
+		}
+	}
+
+	staged := f.staged
+	f.staged = nil
+	f.reloading = false
+	f.actual = actual
+
+	// only the values of the reloadable flags are applied
+	var flags []*Flag
+	for _, flag := range sortFlags(f.formal) {
+		if f.reloadable[flag.Name] && reloaded(flag.Name) {
+			if !canReset(flag.Value) && err == nil {
+				err = fmt.Errorf("flag %s can not be reloaded: its value does not implement Resetter", flag.Name)
+			}
+			flags = append(flags, flag)
+		} else {
+			delete(staged, flag.Name)
+		}
+	}
+	if err == nil {
+		err = f.validateStaged(staged)
+	}
+	if err != nil {
+		f.configRead, f.configExpanded = configRead, configExpanded
+		return nil, err
+	}
+
+	// apply the values to the reloadable flags
+	var changed []string
+	for _, flag := range commitOrder(flags) {
+		before := flag.Value.String()
+
+		values := staged[flag.Name]
+		if r, ok := flag.Value.(Resetter); ok {
+			r.Reset()
+		} else if len(values) == 0 && f.actual[flag.Name] != nil {
+			if err := flag.Value.Set(flag.DefValue); err != nil {
+				return changed, err
+			}
+		}
+		if len(values) == 0 {
+			delete(f.actual, flag.Name)
+			delete(f.origins, flag.Name)
+		}
+		for _, v := range values {
+			if err := f.setConfigValue(flag, v.value, v.pos, v.origin); err != nil {
+				return changed, err
+			}
+		}
+
+		if flag.Value.String() != before {
+			changed = append(changed, flag.Name)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed, f.resolveFlagReferences()
+}
+
+// RLock locks f for reading the values of its flags while they may be
+// changed by a reload on another goroutine, see [FlagSet.ReloadOnSignal]
+// and [FlagSet.WatchConfig]. Reloads wait until the lock is released by
+// [FlagSet.RUnlock].
+func (f *FlagSet) RLock() { f.reloadMu.RLock() }
+
+// RUnlock undoes a single [FlagSet.RLock] call.
+func (f *FlagSet) RUnlock() { f.reloadMu.RUnlock() }
+
+// Lock locks f for changing its flags, for example with [FlagSet.Set], while
+// reloads may run on another goroutine. Reloads wait until the lock is
+// released by [FlagSet.Unlock]; f must not be reloaded while holding it.
+func (f *FlagSet) Lock() { f.reloadMu.Lock() }
+
+// Unlock undoes a [FlagSet.Lock] call.
+func (f *FlagSet) Unlock() { f.reloadMu.Unlock() }
+
+// Reload reads the sources of f again, except for the command line, and
+// applies their values to the flags marked by [FlagSet.SetReloadable] which
+// have not been set on the command line or by [FlagSet.Set]. Reloadable flags
+// no longer set by a source are reset to their default. Reload returns the
+// names of the flags whose value changed.
+func (f *FlagSet) Reload() ([]string, error) {
+	var sources []Source
+	for _, source := range f.configSources() {
+		if _, ok := source.(commandLineSource); !ok {
+			sources = append(sources, source)
+		}
+	}
+	return f.reload(sources, OriginEnv, OriginFile, OriginSource)
+}
+
+// ReloadOnSignal calls [FlagSet.Reload] whenever the process receives one of
+// signals, SIGHUP if none are given and the port has it, and passes its
+// result to onReload. The flags are changed on the goroutine handling the
+// signals, before onReload is called, while holding the lock of f: other
+// goroutines read the values of reloadable flags under [FlagSet.RLock], and
+// change flags under [FlagSet.Lock]. Calling stop stops handling the
+// signals.
+func (f *FlagSet) ReloadOnSignal(onReload func(changed []string, err error), signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		if reloadSignal == nil {
+			return func() {} // no SIGHUP on this port
+		}
+		signals = []os.Signal{reloadSignal}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-c:
+				onReload(f.Reload())
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// ConfigWatcher polls the config files of a [FlagSet] for changes, see
+// [FlagSet.WatchConfig].
+type ConfigWatcher struct {
+	f        *FlagSet
+	onChange func(changed []string, err error)
+	files    map[*configFile]watchedFile
+	entries  []configExpansion
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// watchedFile is the state of a config file when it was last read.
+type watchedFile struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// WatchConfig polls the config files read by the last Parse every interval.
+// When the content of one of them changes, which is detected by its
+// modification time and hash, the config files are read again and their
+// values applied to the flags marked by [FlagSet.SetReloadable]. Flags set
+// on the command line or by another source than the config files keep their
+// value; reloadable flags no longer set by the config files are reset to
+// their default.
+//
+// Entries of the config file list are watched as well: a file created in a
+// directory or matching a glob pattern, an optional file which comes into
+// existence, or a file which is removed also reloads the config files.
+//
+// onChange is called with the names of the flags whose value changed, or the
+// error reading the config files. The flags are changed on the goroutine of
+// the watcher, before onChange is called, while holding the lock of f: other
+// goroutines read the values of reloadable flags under [FlagSet.RLock], and
+// change flags under [FlagSet.Lock]. An error is returned if interval is not
+// positive.
+func (f *FlagSet) WatchConfig(interval time.Duration, onChange func(changed []string, err error)) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("non-positive interval %v for watching config files", interval)
+	}
+
+	w := &ConfigWatcher{
+		f:        f,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.files, w.entries = w.stat()
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.poll()
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Stop stops polling and waits for a running reload to finish.
+func (w *ConfigWatcher) Stop() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// poll reloads the config files if one of them, or the files an entry of the
+// config file list refers to, changed.
+func (w *ConfigWatcher) poll() {
+	changedFile := false
+	for file, state := range w.files {
+		if w.files[file], changedFile = w.check(file, state); changedFile {
+			break
+		}
+	}
+	for _, entry := range w.entries {
+		if changedFile {
+			break
+		}
+		changedFile = entry.changed()
+	}
+	if !changedFile {
+		return
+	}
+
+	changed, err := w.f.reload([]Source{ConfigFileSource()}, OriginFile)
+	w.files, w.entries = w.stat()
+	if err != nil || len(changed) > 0 {
+		w.onChange(changed, err)
+	}
+}
+
+// check returns the current state of file and whether its content differs
+// from state. The content is hashed only if the modification time or size
+// changed.
+func (w *ConfigWatcher) check(file *configFile, state watchedFile) (watchedFile, bool) {
+	info, err := fs.Stat(file.fsys, file.path)
+	if err != nil {
+		return watchedFile{}, state.exists
+	}
+	if state.exists && info.ModTime().Equal(state.modTime) && info.Size() == state.size {
+		return state, false
+	}
+	data, err := fs.ReadFile(file.fsys, file.path)
+	if err != nil {
+		return watchedFile{}, state.exists
+	}
+	current := watchedFile{true, info.ModTime(), info.Size(), sha256.Sum256(data)}
+	return current, !state.exists || current.sum != state.sum
+}
+
+// stat returns the state of the config files read by the last parse, and
+// the entries of the config file list it expanded.
+func (w *ConfigWatcher) stat() (map[*configFile]watchedFile, []configExpansion) {
+	w.f.RLock()
+	defer w.f.RUnlock()
+
+	files := make(map[*configFile]watchedFile)
+	for _, file := range w.f.configRead {
+		files[file], _ = w.check(file, watchedFile{})
+	}
+	return files, w.f.configExpanded
+}
@@ -5,10 +5,17 @@
 package flag
 
 import (
+	"encoding/json"
+	"errors"
 	"gopkg.in/yaml.v3"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -382,3 +389,1870 @@ ar:
 		t.Error("unexpected value for ar")
 	}
 }
+
+func TestParseFileJSON(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	boolFlag := f.Bool("bool", false, "bool value")
+	intFlag := f.Int("int", 0, "int value")
+	float64Flag := f.Float64("float64", 0, "float64 value")
+	durationFlag := f.Duration("duration", 5*time.Second, "time.Duration value")
+	stringFlag := f.String("string", "0", "string value")
+	string3Flag := f.String("string3-env-like", "0", "string3 value")
+	nothingFlag := f.String("nothing", "default", "nothing value")
+
+	if err := f.ParseFile("./testdata/test.json"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *boolFlag != true {
+		t.Error("bool flag should be true, is ", *boolFlag)
+	}
+	if *intFlag != 22 {
+		t.Error("int flag should be 22, is ", *intFlag)
+	}
+	if *float64Flag != 2718e28 {
+		t.Error("float64 flag should be 2718e28, is ", *float64Flag)
+	}
+	if *durationFlag != 2*time.Minute {
+		t.Error("duration flag should be 2m, is ", *durationFlag)
+	}
+	if *stringFlag != "helloJSON" {
+		t.Error("string flag should be `helloJSON`, is ", *stringFlag)
+	}
+	if *string3Flag != "foo" {
+		t.Error("string3-env-like flag should be `foo`, is ", *string3Flag)
+	}
+	if *nothingFlag != "default" {
+		t.Error("nothing flag should be `default`, is ", *nothingFlag)
+	}
+}
+
+func TestParseFileJSONErrors(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	f.String("string", "0", "string value")
+	f.Int("int", 0, "int value")
+
+	expected := `invalid value [1, 2] for configuration variable int at line 3, column 9: only scalar/single values are supported`
+	if err := f.ParseFile("./testdata/bad_test.json"); err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "syntax.json")
+	if err := os.WriteFile(path, []byte("{\n  \"int\": 1,\n  \"string\": x\n}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f = NewFlagSet("test", ContinueOnError)
+	f.Int("int", 0, "int value")
+	if err := f.ParseFile(path); err == nil || !strings.Contains(err.Error(), "line 3, column 13") {
+		t.Errorf("expected syntax error at line 3, column 13, got: %v", err)
+	}
+}
+
+func TestDefaultConfigFlagnameJSON(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	stringFlag := f.String("string", "0", "string value")
+
+	f.String(DefaultConfigFlagname, "./testdata/test.json:./testdata/test.yml", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+
+	if err := f.Parse([]string{}); err != nil {
+		t.Error("parse failed; ", err)
+	}
+
+	if *stringFlag != "helloJSON" {
+		t.Error("string flag should be `helloJSON`, is", *stringFlag)
+	}
+}
+
+func TestParseFileTOML(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	stringFlag := f.String("string", "0", "string value")
+	string3Flag := f.String("string3-env-like", "0", "string3 value")
+	intFlag := f.Int("int", 0, "int value")
+	float64Flag := f.Float64("float64", 0, "float64 value")
+	boolFlag := f.Bool("bool", false, "bool value")
+	hostFlag := f.String("db.host", "", "database host")
+	portFlag := f.Int("db.port", 0, "database port")
+	sizeFlag := f.Int("db.pool.size", 0, "database pool size")
+	tagsFlag := f.StringList("tags", nil, "tags")
+
+	if err := f.Set("db.port", "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.ParseFile("./testdata/test.toml"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "helloTOML" {
+		t.Error("string flag should be `helloTOML`, is ", *stringFlag)
+	}
+	if *string3Flag != "foo" {
+		t.Error("string3-env-like flag should be `foo`, is ", *string3Flag)
+	}
+	if *intFlag != 22 {
+		t.Error("int flag should be 22, is ", *intFlag)
+	}
+	if *float64Flag != 2718e28 {
+		t.Error("float64 flag should be 2718e28, is ", *float64Flag)
+	}
+	if *boolFlag != true {
+		t.Error("bool flag should be true, is ", *boolFlag)
+	}
+	if *hostFlag != "localhost" {
+		t.Error("db.host flag should be `localhost`, is ", *hostFlag)
+	}
+	if *portFlag != 1234 {
+		t.Error("db.port flag should keep 1234, is ", *portFlag)
+	}
+	if *sizeFlag != 10 {
+		t.Error("db.pool.size flag should be 10, is ", *sizeFlag)
+	}
+	if origin := f.Origin("string"); origin.Line != 2 {
+		t.Error("string flag should be from line 2, is ", origin)
+	}
+	if origin := f.Origin("db.pool.size"); origin.Line != 14 {
+		t.Error("db.pool.size flag should be from line 14, is ", origin)
+	}
+	if strings.Join(*tagsFlag, ",") != "a,b" {
+		t.Error("tags flag should be `a,b`, is ", *tagsFlag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	f.StringList("tags", nil, "tags")
+	err := f.ParseReader(strings.NewReader("# nested\ntags = [[1, 2]]\n"), "toml")
+	if err == nil || !strings.Contains(err.Error(), "in file '<reader>' at line 2") {
+		t.Error("expected error with file and line, got ", err)
+	}
+}
+
+func TestParseFileYAMLNested(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	hostFlag := f.String("db.host", "", "database host")
+	portFlag := f.Int("db.port", 0, "database port")
+	sizeFlag := f.Int("db.pool.size", 0, "database pool size")
+	userFlag := f.String("db.user", "", "database user")
+	cacheHostFlag := f.String("cache.host", "", "cache host")
+	cacheTimeoutFlag := f.Duration("cache.timeout", 0, "cache timeout")
+
+	if err := f.ParseFile("./testdata/nested.yml"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *hostFlag != "localhost" {
+		t.Error("db.host flag should be `localhost`, is ", *hostFlag)
+	}
+	if *portFlag != 5432 {
+		t.Error("db.port flag should be 5432, is ", *portFlag)
+	}
+	if *sizeFlag != 10 {
+		t.Error("db.pool.size flag should be 10, is ", *sizeFlag)
+	}
+	if *userFlag != "admin" {
+		t.Error("db.user flag should be `admin`, is ", *userFlag)
+	}
+	if *cacheHostFlag != "redis" {
+		t.Error("cache.host flag should be `redis`, is ", *cacheHostFlag)
+	}
+	if *cacheTimeoutFlag != 5*time.Second {
+		t.Error("cache.timeout flag should be 5s, is ", *cacheTimeoutFlag)
+	}
+}
+
+// listValue is a repeatable flag which accumulates every value set
+type listValue []string
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+func (l *listValue) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func TestParseFileYAMLSequence(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+
+	var hosts, ports listValue
+	f.Var(&hosts, "hosts", "list of hosts")
+	f.Var(&ports, "ports", "list of ports")
+
+	if err := f.ParseFile("./testdata/sequence.yml"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if hosts.String() != "alpha,beta" {
+		t.Error("hosts flag should be `alpha,beta`, is ", hosts.String())
+	}
+	if ports.String() != "80,443" {
+		t.Error("ports flag should be `80,443`, is ", ports.String())
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	f.Int("bad", 0, "int value")
+
+	expected := `invalid value "two" for configuration variable bad at line 7: parse error`
+	if err := f.ParseFile("./testdata/sequence.yml"); err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+}
+
+func TestParseFileDotenv(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	string3Flag := f.String("string3-env-like", "0", "string3 value")
+	multiFlag := f.String("multi", "", "multi-line value")
+	intFlag := f.Int("int", 0, "int value")
+	boolFlag := f.Bool("bool", false, "bool value")
+
+	if err := f.ParseFile("./testdata/test.env"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "helloDOTENV" {
+		t.Error("string flag should be `helloDOTENV`, is ", *stringFlag)
+	}
+	if *string2Flag != "single $quoted # not a comment" {
+		t.Error("string2 flag should be single quoted literally, is ", *string2Flag)
+	}
+	if *string3Flag != "double \"quoted\"\tvalue" {
+		t.Error("string3-env-like flag should be unescaped, is ", *string3Flag)
+	}
+	if *multiFlag != "first line\nsecond line" {
+		t.Error("multi flag should span two lines, is ", *multiFlag)
+	}
+	if *intFlag != 22 {
+		t.Error("int flag should be 22, is ", *intFlag)
+	}
+	if *boolFlag != true {
+		t.Error("bool flag should be true, is ", *boolFlag)
+	}
+}
+
+func TestDefaultConfigFlagnameDotenvPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := os.WriteFile(path, []byte("STRING=\"hello dotenv\" # comment\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	f.String(DefaultConfigFlagname, "dotenv:"+path+":./testdata/test.yml", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{}); err != nil {
+		t.Error("parse failed; ", err)
+	}
+	if *stringFlag != "hello dotenv" {
+		t.Error("string flag should be `hello dotenv`, is ", *stringFlag)
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"A=1\nB":             "line 2: expected KEY=value",
+		"A=\"open\n\nB=2":    "line 1: unterminated quoted value",
+		"A='closed' extra\n": `line 1: unexpected "extra" after quoted value`,
+	} {
+		if _, err := parseDotenv(input); err == nil || err.Error() != expected {
+			t.Errorf("expected error %q for %q, got: %v", expected, input, err)
+		}
+	}
+}
+
+func TestParseFileINI(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	stringFlag := f.String("string", "0", "string value")
+	verboseFlag := f.Bool("verbose", false, "bool value")
+	hostFlag := f.String("database.host", "", "database host")
+	portFlag := f.Int("database.port", 0, "database port")
+	dsnFlag := f.String("database.dsn", "", "database dsn")
+	ttlFlag := f.Duration("cache-ttl", 0, "cache ttl")
+	nameFlag := f.String("cache-name", "", "cache name")
+	optsFlag := f.String("cache-opts", "", "cache options")
+
+	if err := f.ParseFile("./testdata/test.ini"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "helloINI" {
+		t.Error("string flag should be `helloINI`, is ", *stringFlag)
+	}
+	if *verboseFlag != true {
+		t.Error("verbose flag should be true, is ", *verboseFlag)
+	}
+	if *hostFlag != "localhost" {
+		t.Error("database.host flag should be `localhost`, is ", *hostFlag)
+	}
+	if *portFlag != 5432 {
+		t.Error("database.port flag should be 5432, is ", *portFlag)
+	}
+	if *dsnFlag != "postgres://user@localhost/app?sslmode=disable" {
+		t.Error("database.dsn flag should be joined, is ", *dsnFlag)
+	}
+	if *ttlFlag != 2*time.Minute {
+		t.Error("cache-ttl flag should be 2m, is ", *ttlFlag)
+	}
+	if *nameFlag != "x ; y" || *optsFlag != "a;b" {
+		t.Errorf("only comments after a space should be stripped, name is %q, opts is %q", *nameFlag, *optsFlag)
+	}
+}
+
+func TestParseFileAs(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+
+	if err := f.ParseFileAs("./testdata/noext", "yaml"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "helloYAML" {
+		t.Error("string flag should be `helloYAML`, is ", *stringFlag)
+	}
+
+	if err := f.ParseFileAs("./testdata/noext", "xml"); err == nil {
+		t.Error("expected error for unknown format, got nil")
+	}
+}
+
+func TestDefaultConfigFlagnameFormatPrefix(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+
+	f.String(DefaultConfigFlagname, "yaml:./testdata/noext:text:./testdata/test.conf", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{}); err != nil {
+		t.Error("parse failed; ", err)
+	}
+	if *stringFlag != "helloYAML" {
+		t.Error("string flag should be `helloYAML`, is ", *stringFlag)
+	}
+	if *string2Flag != "world" {
+		t.Error("string2 flag should be `world`, is ", *string2Flag)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	intFlag := f.Int("int", 0, "int value")
+
+	if err := f.ParseReader(strings.NewReader("string hello\n"), ""); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if err := f.ParseReader(strings.NewReader(`{"int": 22}`), "json"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "hello" {
+		t.Error("string flag should be `hello`, is ", *stringFlag)
+	}
+	if *intFlag != 22 {
+		t.Error("int flag should be 22, is ", *intFlag)
+	}
+}
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"defaults/app.yaml": {Data: []byte("string: helloFS\n")},
+		"defaults/app":      {Data: []byte("int=22\n")},
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	intFlag := f.Int("int", 0, "int value")
+
+	if err := f.ParseFS(fsys, "defaults/app.yaml"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if err := f.ParseFS(fsys, "text:defaults/app"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "helloFS" {
+		t.Error("string flag should be `helloFS`, is ", *stringFlag)
+	}
+	if *intFlag != 22 {
+		t.Error("int flag should be 22, is ", *intFlag)
+	}
+
+	if err := f.ParseFS(fsys, "defaults/missing.yaml"); err == nil {
+		t.Error("expected error of missing config file, got nil")
+	}
+}
+
+func TestSetConfigFS(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetConfigFS(fstest.MapFS{
+		"app.json": {Data: []byte(`{"string": "helloFS"}`)},
+	})
+
+	stringFlag := f.String("string", "0", "string value")
+	f.String(DefaultConfigFlagname, "app.json", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{}); err != nil {
+		t.Error("parse failed; ", err)
+	}
+	if *stringFlag != "helloFS" {
+		t.Error("string flag should be `helloFS`, is ", *stringFlag)
+	}
+}
+
+func TestDefaultConfigFlagnameDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"10-base.conf":  "string first\nint 1\n",
+		"20-more.yaml":  "string: second\nstring2: second\n",
+		"30-last.json":  `{"int": 3, "ratio": 3.5}`,
+		"README":        "string readme\n",
+		"sub/40-x.conf": "string2 sub\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	intFlag := f.Int("int", 0, "int value")
+	ratioFlag := f.Float64("ratio", 0, "float64 value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{"-config", dir}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "first" {
+		t.Error("string flag should be `first`, is ", *stringFlag)
+	}
+	if *string2Flag != "second" {
+		t.Error("string2 flag should be `second`, is ", *string2Flag)
+	}
+	if *intFlag != 1 {
+		t.Error("int flag should be 1, is ", *intFlag)
+	}
+	if *ratioFlag != 3.5 {
+		t.Error("ratio flag should be 3.5, is ", *ratioFlag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	stringFlag = f.String("string", "0", "string value")
+	intFlag = f.Int("int", 0, "int value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := f.Parse([]string{"-config", filepath.Join(dir, "*-[lm]*")}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "second" {
+		t.Error("string flag should be `second`, is ", *stringFlag)
+	}
+	if *intFlag != 3 {
+		t.Error("int flag should be 3, is ", *intFlag)
+	}
+}
+
+func TestParseFileInclude(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	intFlag := f.Int("int", 0, "int value")
+
+	if err := f.ParseFile("./testdata/include/main.conf"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "main" {
+		t.Error("string flag should be `main`, is ", *stringFlag)
+	}
+	if *intFlag != 7 {
+		t.Error("int flag should be 7, is ", *intFlag)
+	}
+	if *string2Flag != "more" {
+		t.Error("string2 flag should be `more`, is ", *string2Flag)
+	}
+
+	expected := "include cycle in config file 'testdata/include/cycle-a.conf': ./testdata/include/cycle-a.conf -> testdata/include/cycle-b.conf -> testdata/include/cycle-a.conf"
+	if err := f.ParseFile("./testdata/include/cycle-a.conf"); err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+}
+
+func TestParseFileYAMLInclude(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	hostFlag := f.String("db.host", "", "database host")
+	intFlag := f.Int("int", 0, "int value")
+
+	if err := f.ParseFile("./testdata/include/main.yaml"); err != nil {
+		t.Fatal("expected no error; got ", err)
+	}
+	if *stringFlag != "common" {
+		t.Error("string flag should be `common`, is ", *stringFlag)
+	}
+	if *hostFlag != "localhost" {
+		t.Error("db.host flag should be `localhost`, is ", *hostFlag)
+	}
+	if *intFlag != 8 {
+		t.Error("int flag should be 8, is ", *intFlag)
+	}
+
+	if err := f.ParseFile("./testdata/include/cycle.yaml"); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("expected include cycle error, got: %v", err)
+	}
+}
+
+func TestConfigSearchPaths(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	os.Unsetenv("FLAG_TEST_UNSET")
+	for name, content := range map[string]string{
+		"xdg/app/config.yaml": "string: xdg\n",
+		"etc/app/app.conf":    "string etc\nint 22\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	intFlag := f.Int("int", 0, "int value")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetConfigSearchPaths(
+		"$FLAG_TEST_UNSET/app/config.yaml",
+		"$XDG_CONFIG_HOME/app/config.yaml",
+		filepath.Join(dir, "missing.conf"),
+		filepath.Join(dir, "etc/app"),
+	)
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "xdg" {
+		t.Error("string flag should be `xdg`, is ", *stringFlag)
+	}
+	if *intFlag != 22 {
+		t.Error("int flag should be 22, is ", *intFlag)
+	}
+
+	// an explicit config file disables the search
+	f = NewFlagSet("test", ContinueOnError)
+	stringFlag = f.String("string", "0", "string value")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetConfigSearchPaths("$XDG_CONFIG_HOME/app/config.yaml")
+
+	if err := f.Parse([]string{"-config", "./testdata/test.conf"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "hello" {
+		t.Error("string flag should be `hello`, is ", *stringFlag)
+	}
+}
+
+func TestDefaultConfigFlagnameOptionalFile(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	stringFlag := f.String("string", "0", "string value")
+	f.Int("int", 0, "int value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{"-config", "?./testdata/missing.yaml:?yaml:./testdata/missing:./testdata/test.yml"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "helloYAML" {
+		t.Error("string flag should be `helloYAML`, is ", *stringFlag)
+	}
+
+	// existing optional files still report their errors
+	if err := f.Parse([]string{"-config", "?./testdata/bad_test.conf"}); err == nil {
+		t.Error("expected error of bad config file, got nil")
+	}
+}
+
+func TestSplitConfigFiles(t *testing.T) {
+	tests := []struct {
+		cFile string
+		sep   string
+		files []string
+	}{
+		{"a.conf:b.yaml", ":", []string{"a.conf", "b.yaml"}},
+		{`c\:/app.conf:yaml:/run/app`, ":", []string{"c:/app.conf", "yaml:/run/app"}},
+		{"yaml:/run/app,?/etc/app.conf", ",", []string{"yaml:/run/app", "?/etc/app.conf"}},
+		{`a\,b.conf,c.conf`, ",", []string{"a,b.conf", "c.conf"}},
+	}
+	for _, test := range tests {
+		files := splitConfigFiles(test.cFile, test.sep)
+		if strings.Join(files, "|") != strings.Join(test.files, "|") {
+			t.Errorf("splitConfigFiles(%q, %q) = %q, want %q", test.cFile, test.sep, files, test.files)
+		}
+	}
+}
+
+func TestDefaultConfigFlagnameSeparator(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetConfigSeparator(",")
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{"-config", "yaml:./testdata/noext,./testdata/test.conf"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "helloYAML" {
+		t.Error("string flag should be `helloYAML`, is ", *stringFlag)
+	}
+	if *string2Flag != "world" {
+		t.Error("string2 flag should be `world`, is ", *string2Flag)
+	}
+}
+
+func TestDefaultConfigFlagnameRepeatable(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	configFlag := f.StringList(DefaultConfigFlagname, []string{"./testdata/missing"}, "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{"-config", "./testdata/test.yml", "-config", "./testdata/test.conf"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if len(*configFlag) != 2 {
+		t.Error("config flag should hold 2 files, holds ", *configFlag)
+	}
+	if *stringFlag != "helloYAML" {
+		t.Error("string flag should be `helloYAML`, is ", *stringFlag)
+	}
+	if *string2Flag != "world" {
+		t.Error("string2 flag should be `world`, is ", *string2Flag)
+	}
+
+	// a value from the environment holds a list of entries
+	f = NewFlagSet("test", ContinueOnError)
+	stringFlag = f.String("string", "0", "string value")
+	string2Flag = f.String("string2", "0", "string2 value")
+	configFlag = f.StringList(DefaultConfigFlagname, nil, "config path")
+	f.SetSources(EnvSource([]string{"CONFIG=./testdata/test.yml:./testdata/test.conf"}), ConfigFileSource())
+
+	if err := f.Parse(nil); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "helloYAML" || *string2Flag != "world" {
+		t.Error("flags should be read from both files, are ", *stringFlag, *string2Flag)
+	}
+}
+
+func TestDetectConfigFormat(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":                              "text",
+		"  {\"string\": \"x\"}":         "json",
+		"# comment\nstring: x\n":        "yaml",
+		"---\nstring: x\n":              "yaml",
+		"export STRING=x\n":             "dotenv",
+		"STRING=\"x\"\n":                "dotenv",
+		"; comment\n[section]\nkey=x\n": "ini",
+		"key = x\n":                     "ini",
+		"string x\n":                    "text",
+		"bool\n":                        "text",
+		"string:x\n":                    "text",
+		"db:\n  host: x\n":              "yaml",
+	} {
+		if format := detectConfigFormat([]byte(input)); format != expected {
+			t.Errorf("detectConfigFormat(%q) = %q, want %q", input, format, expected)
+		}
+	}
+}
+
+func TestDefaultConfigFlagnameStdin(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.stdin = strings.NewReader("string: helloStdin\n")
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := os.Unsetenv("STRING"); err != nil {
+		t.Error(err)
+	}
+	if err := f.Parse([]string{"-config", "-:./testdata/test.conf"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "helloStdin" {
+		t.Error("string flag should be `helloStdin`, is ", *stringFlag)
+	}
+	if *string2Flag != "world" {
+		t.Error("string2 flag should be `world`, is ", *string2Flag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.stdin = strings.NewReader("string=helloStdin")
+	stringFlag = f.String("string", "0", "string value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := f.Parse([]string{"-config", "text:-"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "helloStdin" {
+		t.Error("string flag should be `helloStdin`, is ", *stringFlag)
+	}
+
+	if err := f.Parse([]string{"-config", "-:-"}); err == nil || !strings.Contains(err.Error(), "already been read") {
+		t.Error("expected error reading standard input twice, got ", err)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.stdin = strings.NewReader("string:helloStdin\n")
+	stringFlag = f.String("string", "0", "string value")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := f.Parse([]string{"-config", "-"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "helloStdin" {
+		t.Error("string flag should be `helloStdin`, is ", *stringFlag)
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	for _, interpolation := range []Interpolation{InterpolationOff, InterpolationStrict} {
+		for _, format := range []string{"text", "yaml", "dotenv"} {
+			f := NewFlagSet("test", ContinueOnError)
+			f.SetInterpolation(interpolation)
+			f.String(DefaultConfigFlagname, "", "config path")
+			f.Bool("w-bool", false, "bool value")
+			f.Int("w-int", 0, "int value")
+			f.String("w-string", "", "string value\nspanning lines")
+			f.Duration("w-duration", 0, "duration value")
+			f.StringList("w-list", nil, "list value")
+			f.EnablePrintConfig()
+			args := []string{"-w-bool", "-w-int", "22", "-w-string", "hello: `${x}` \"#\" $$", "-w-duration", "1m", "-w-list", "a,b"}
+			if format != "dotenv" {
+				args = append(args, "-w-list", "${c} d")
+			}
+			if err := f.Parse(args); err != nil {
+				t.Fatal("parse failed; ", err)
+			}
+
+			var b strings.Builder
+			if err := f.WriteConfig(&b, format); err != nil {
+				t.Fatalf("%s: write failed; %v", format, err)
+			}
+			if strings.Contains(b.String(), DefaultConfigFlagname) || strings.Contains(b.String(), PrintConfigFlagname) {
+				t.Errorf("%s: config and print-config flags should not be written:\n%s", format, b.String())
+			}
+			if !strings.Contains(b.String(), "# spanning lines\n") {
+				t.Errorf("%s: usage should be written as comment:\n%s", format, b.String())
+			}
+
+			g := NewFlagSet("test", ContinueOnError)
+			g.SetInterpolation(interpolation)
+			g.Bool("w-bool", false, "")
+			g.Int("w-int", 0, "")
+			g.String("w-string", "", "")
+			g.Duration("w-duration", 0, "")
+			list := g.StringList("w-list", nil, "")
+			g.EnablePrintConfig()
+			if err := g.ParseReader(strings.NewReader(b.String()), format); err != nil {
+				t.Fatalf("%s: reading written config failed; %v\n%s", format, err, b.String())
+			}
+			f.VisitAll(func(flag *Flag) {
+				if flag.Name == DefaultConfigFlagname || flag.Name == PrintConfigFlagname {
+					return
+				}
+				if value := g.Lookup(flag.Name).Value.String(); value != flag.Value.String() {
+					t.Errorf("%s: flag %s should be %q, is %q\n%s", format, flag.Name, flag.Value.String(), value, b.String())
+				}
+			})
+			if expected := f.Lookup("w-list").Value.(Getter).Get().([]string); len(*list) != len(expected) {
+				t.Errorf("%s: list flag should be %q, is %q\n%s", format, expected, *list, b.String())
+			}
+		}
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	f.StringList("w-list", nil, "list value")
+	if err := f.Parse([]string{"-w-list", "a", "-w-list", "b"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if err := f.WriteConfig(io.Discard, "dotenv"); err == nil || !strings.Contains(err.Error(), "list") {
+		t.Error("expected error writing a list as dotenv, got ", err)
+	}
+}
+
+func TestWriteChangedConfig(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.Int("w-int", 0, "int value")
+	f.String("w-string", "x", "string value")
+	if err := f.Parse([]string{"-w-int", "3"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+
+	var b strings.Builder
+	if err := f.WriteChangedConfig(&b, "text"); err != nil {
+		t.Fatal("write failed; ", err)
+	}
+	if b.String() != "# int value\nw-int 3\n" {
+		t.Errorf("unexpected changed config %q", b.String())
+	}
+
+	if err := f.Set("w-string", "a\nb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteConfig(&b, "text"); err == nil {
+		t.Error("expected error writing multi-line value as plain text")
+	}
+	if err := f.WriteConfig(&b, "xml"); err == nil {
+		t.Error("expected error writing unsupported format")
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationLenient)
+	f.String("i-str", "", "")
+	str := f.Lookup("i-str")
+	if err := f.ParseEnv([]string{"A=a", "EMPTY="}); err != nil {
+		t.Fatal(err)
+	}
+
+	for input, expected := range map[string]string{
+		"plain":                   "plain",
+		"${A}-${A}":               "a-a",
+		"$A ${A}":                 "$A a",
+		"$$${A} $$ $":             "$a $ $",
+		"${EMPTY:-x}":             "x",
+		"${UNSET:-${A}/b}":        "a/b",
+		"${UNSET:-}${UNSET}":      "",
+		"${UNSET:-${UNSET:-}}}":   "}",
+		"${UNSET:-$${A\\}}":       "${A\\}",
+		"x${A:-${UNSET:-}b}y${A}": "xaya",
+		// flag references keep "$" escaped for resolveFlagReferences
+		"$$ ${flag:b}":  "$$ ${flag:b}",
+		"$${flag:b} $A": "$${flag:b} $$A",
+	} {
+		value, err := f.interpolate(str, input)
+		if err != nil {
+			t.Errorf("interpolate(%q) failed: %v", input, err)
+		} else if value != expected {
+			t.Errorf("interpolate(%q) = %q, want %q", input, value, expected)
+		}
+	}
+
+	for _, input := range []string{"${A", "${}", "${:-x}", "${UNSET:-${A}"} {
+		if _, err := f.interpolate(str, input); err == nil {
+			t.Errorf("interpolate(%q) should fail", input)
+		}
+	}
+
+	f.SetInterpolation(InterpolationStrict)
+	if _, err := f.interpolate(str, "${UNSET:-x}${EMPTY}"); err != nil {
+		t.Error("defaults and empty variables should expand in strict mode; ", err)
+	}
+	if _, err := f.interpolate(str, "${UNSET}"); err == nil || !strings.Contains(err.Error(), `undefined variable "UNSET"`) {
+		t.Error("expected undefined variable error, got ", err)
+	}
+}
+
+func TestParseFileInterpolation(t *testing.T) {
+	for _, path := range []string{"./testdata/interpolate.conf", "./testdata/interpolate.yaml"} {
+		f := NewFlagSet("test", ContinueOnError)
+		f.SetInterpolation(InterpolationStrict)
+		dsnFlag := f.String("dsn", "", "dsn value")
+		priceFlag := f.String("price", "", "price value")
+
+		if err := f.ParseEnv([]string{"I_DB_USER=app"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.ParseFile(path); err != nil {
+			t.Fatalf("%s: parse failed; %v", path, err)
+		}
+		if *dsnFlag != "postgres://app@localhost/app" {
+			t.Errorf("%s: dsn flag should be `postgres://app@localhost/app`, is %s", path, *dsnFlag)
+		}
+		if *priceFlag != "$5" {
+			t.Errorf("%s: price flag should be `$5`, is %s", path, *priceFlag)
+		}
+
+		f = NewFlagSet("test", ContinueOnError)
+		f.SetInterpolation(InterpolationStrict)
+		f.String("dsn", "", "dsn value")
+		if err := f.ParseEnv(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.ParseFile(path); err == nil || !strings.Contains(err.Error(), `undefined variable "I_DB_USER"`) {
+			t.Errorf("%s: expected undefined variable error, got %v", path, err)
+		}
+
+		f = NewFlagSet("test", ContinueOnError)
+		dsnFlag = f.String("dsn", "", "dsn value")
+		if err := f.ParseFile(path); err != nil {
+			t.Fatalf("%s: parse failed; %v", path, err)
+		}
+		if *dsnFlag != "postgres://${I_DB_USER}@${I_DB_HOST:-localhost}/app" {
+			t.Errorf("%s: dsn flag should not be interpolated by default, is %s", path, *dsnFlag)
+		}
+	}
+}
+
+func TestParseEnvInterpolation(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationStrict)
+	urlFlag := f.String("i-url", "", "url value")
+
+	if err := f.ParseEnv([]string{"I_HOST=example.com", "I_URL=https://${I_HOST}/"}); err != nil {
+		t.Fatal(err)
+	}
+	if *urlFlag != "https://example.com/" {
+		t.Error("url flag should be `https://example.com/`, is ", *urlFlag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationStrict)
+	f.String("i-url", "", "url value")
+	if err := f.ParseEnv([]string{"I_URL=https://${I_HOST}/"}); err == nil || !strings.Contains(err.Error(), "environment variable i-url") {
+		t.Error("expected undefined variable error, got ", err)
+	}
+}
+
+func TestResolveFlagReferences(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationStrict)
+	logFileFlag := f.String("log-file", "", "log file")
+	logNameFlag := f.String("log-name", "${flag:port}-${flag:data-dir}", "log name")
+	dataDirFlag := f.String("data-dir", "", "data dir")
+	f.Int("port", 8080, "port")
+	f.String(DefaultConfigFlagname, "", "config path")
+
+	if err := f.Parse([]string{"-config", "./testdata/references.conf", "-data-dir", "/srv"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *dataDirFlag != "/srv" {
+		t.Error("data-dir flag should be `/srv`, is ", *dataDirFlag)
+	}
+	if *logNameFlag != "8080-/srv" {
+		t.Error("log-name flag should be `8080-/srv`, is ", *logNameFlag)
+	}
+	if *logFileFlag != "/srv/8080-/srv.log" {
+		t.Error("log-file flag should be `/srv/8080-/srv.log`, is ", *logFileFlag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationLenient)
+	f.String("a", "${flag:b}", "")
+	f.String("b", "x${flag:c}", "")
+	f.String("c", "${flag:a}", "")
+	if err := f.Parse(nil); err == nil || !strings.Contains(err.Error(), "cyclic flag reference: a -> b -> c -> a") {
+		t.Error("expected cyclic reference error, got ", err)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationLenient)
+	f.String("a", "${flag:missing}", "")
+	if err := f.Parse(nil); err == nil || !strings.Contains(err.Error(), `undefined flag "missing"`) {
+		t.Error("expected undefined flag error, got ", err)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	aFlag := f.String("a", "${flag:missing}", "")
+	if err := f.Parse(nil); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *aFlag != "${flag:missing}" {
+		t.Error("references should not be resolved by default, a is ", *aFlag)
+	}
+
+	// "$$" escapes references, whatever the source
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetInterpolation(InterpolationStrict)
+	aFlag = f.String("a", "", "")
+	cFlag := f.String("c", "", "")
+	f.String("b", "B", "")
+	if err := f.ParseReader(strings.NewReader("a $${flag:b} $$ ${flag:b}\n"), "text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Parse([]string{"-c", "$${flag:b}$"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *aFlag != "${flag:b} $ B" || *cFlag != "${flag:b}$" {
+		t.Errorf("escaped references should be kept, a is %q, c is %q", *aFlag, *cFlag)
+	}
+}
+
+func TestSources(t *testing.T) {
+	secrets := LookupFunc(func(name string) (string, bool, error) {
+		if name == "s-password" {
+			return "secret", true, nil
+		}
+		return "", false, nil
+	})
+	settings := ListFunc(func() ([]KeyValue, error) {
+		return []KeyValue{
+			{"s-password", "fromList"},
+			{"S_NAME", "fromList"},
+			{"unknown", "x"},
+			{"s-port", "8080"},
+		}, nil
+	})
+
+	f := NewFlagSet("test", ContinueOnError)
+	passwordFlag := f.String("s-password", "", "password")
+	nameFlag := f.String("s-name", "", "name")
+	portFlag := f.Int("s-port", 0, "port")
+	f.SetSources(EnvSource([]string{"S_PORT=1"}), secrets, settings)
+
+	if err := f.Parse([]string{"-s-name", "fromArgs"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *passwordFlag != "secret" {
+		t.Error("password flag should be `secret`, is ", *passwordFlag)
+	}
+	if *nameFlag != "fromArgs" {
+		t.Error("name flag should be `fromArgs`, is ", *nameFlag)
+	}
+	if *portFlag != 1 {
+		t.Error("port flag should be 1, is ", *portFlag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.String("s-password", "", "password")
+	f.String(DefaultConfigFlagname, "./testdata/test.conf", "config path")
+	f.SetSources()
+	if err := f.Parse(nil); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if f.actual["s-password"] != nil {
+		t.Error("no source should be applied")
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	portFlag = f.Int("s-port", 0, "port")
+	f.AddSource(ListFunc(func() ([]KeyValue, error) {
+		return []KeyValue{{"s-port", "x"}}, nil
+	}))
+	if err := f.Parse(nil); err == nil || !strings.Contains(err.Error(), `invalid value "x" for configuration variable s-port`) {
+		t.Error("expected invalid value error, got ", err)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.Int("s-port", 0, "port")
+	f.AddSource(LookupFunc(func(name string) (string, bool, error) {
+		return "", false, errors.New("unavailable")
+	}))
+	if err := f.Parse(nil); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Error("expected lookup error, got ", err)
+	}
+}
+
+func TestSourcesPrecedence(t *testing.T) {
+	f := NewFlagSet("test", ContinueOnError)
+	stringFlag := f.String("string", "0", "string value")
+	string2Flag := f.String("string2", "0", "string2 value")
+	intFlag := f.Int("p-int", 0, "int value")
+	f.String(DefaultConfigFlagname, "./testdata/test.conf", "config path")
+	f.SetSources(ConfigFileSource(), EnvSource([]string{"STRING=fromEnv", "P_INT=2"}))
+
+	if err := f.Parse(nil); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "hello" {
+		t.Error("string flag should be `hello` from the file, is ", *stringFlag)
+	}
+	if *intFlag != 2 {
+		t.Error("int flag should be 2 from the environment, is ", *intFlag)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	stringFlag = f.String("string", "0", "string value")
+	string2Flag = f.String("string2", "0", "string2 value")
+	intFlag = f.Int("p-int", 0, "int value")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetSources(EnvSource([]string{"STRING=fromEnv"}), CommandLineSource(), ConfigFileSource())
+
+	if err := f.Parse([]string{"-string", "fromArgs", "-string2", "fromArgs", "-p-int", "3", "-config", "./testdata/test.conf"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *stringFlag != "fromEnv" {
+		t.Error("string flag should be `fromEnv`, is ", *stringFlag)
+	}
+	if *string2Flag != "fromArgs" {
+		t.Error("string2 flag should be `fromArgs`, is ", *string2Flag)
+	}
+	if *intFlag != 3 {
+		t.Error("int flag should be 3, is ", *intFlag)
+	}
+	if len(f.actual) != 4 {
+		t.Error("all flags should be set, set are ", len(f.actual))
+	}
+}
+
+func TestSourcesPrecedenceList(t *testing.T) {
+	for _, transactional := range []bool{false, true} {
+		f := NewFlagSet("test", ContinueOnError)
+		f.SetTransactional(transactional)
+		listFlag := f.StringList("p-list", []string{"default"}, "list value")
+		otherFlag := f.StringList("p-other", nil, "other list value")
+		f.SetSources(ListFunc(func() ([]KeyValue, error) {
+			return []KeyValue{{"p-list", "fromSource"}}, nil
+		}), CommandLineSource())
+
+		if err := f.Parse([]string{"-p-list", "cli", "-p-other", "cli"}); err != nil {
+			t.Fatal("parse failed; ", err)
+		}
+		if strings.Join(*listFlag, ",") != "fromSource" {
+			t.Errorf("transactional %v: list flag should be replaced by the source, is %q", transactional, *listFlag)
+		}
+		if strings.Join(*otherFlag, ",") != "cli" {
+			t.Errorf("transactional %v: other list flag should keep its value, is %q", transactional, *otherFlag)
+		}
+	}
+
+	for _, transactional := range []bool{false, true} {
+		f := NewFlagSet("test", ContinueOnError)
+		f.SetOutput(io.Discard)
+		f.SetTransactional(transactional)
+		f.String("p-name", "", "name")
+		f.Int("p-int", 0, "int value")
+		f.SetSources(ListFunc(func() ([]KeyValue, error) {
+			return []KeyValue{{"p-int", "x"}}, nil
+		}), CommandLineSource())
+
+		if err := f.Parse([]string{"-p-name", "cli"}); err == nil {
+			t.Fatal("expected invalid value error")
+		}
+		if f.actual["p-name"] == nil {
+			t.Errorf("transactional %v: flags set on the command line should stay set after an error", transactional)
+		}
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlagSetWithExtras("test", ContinueOnError, "", true, false)
+	f.String("string", "0", "string value")
+	f.String("string2", "0", "string2 value")
+	f.String("string3-env-like", "0", "string3 value")
+	f.Int("int", 0, "int value")
+	f.Int("o-port", 0, "port value")
+	f.String("o-password", "", "password value")
+	f.Bool("o-unset", false, "unset value")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetSources(
+		EnvSource([]string{"O_PORT=1", "O_PASSWORD_FILE=" + secretFile}),
+		ConfigFileSource(),
+		ListFunc(func() ([]KeyValue, error) { return []KeyValue{{"INT", "3"}}, nil }),
+	)
+
+	if err := f.Parse([]string{"-config", "./testdata/test.json:./testdata/test.conf", "-string2", "x"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if err := f.Set("int", "4"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Origin{
+		DefaultConfigFlagname: {Kind: OriginCommandLine},
+		"string2":             {Kind: OriginCommandLine},
+		"o-port":              {Kind: OriginEnv, Key: "O_PORT"},
+		"o-password":          {Kind: OriginEnv, Key: "O_PASSWORD_FILE", File: secretFile},
+		"string":              {Kind: OriginFile, File: "./testdata/test.json", Line: 6},
+		"string3-env-like":    {Kind: OriginFile, File: "./testdata/test.json", Line: 7},
+		"int":                 {Kind: OriginSet},
+		"o-unset":             {},
+	}
+	for name, origin := range expected {
+		if got := f.Origin(name); got != origin {
+			t.Errorf("origin of %s should be %v, is %v", name, origin, got)
+		}
+	}
+
+	visited := 0
+	f.VisitOrigin(func(flag *Flag, origin Origin) {
+		visited++
+		if origin.Kind == OriginDefault {
+			t.Errorf("visited flag %s should have been set", flag.Name)
+		}
+	})
+	if visited != len(expected)-1 {
+		t.Errorf("visited %d flags, want %d", visited, len(expected)-1)
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.String("string2", "0", "string2 value")
+	f.Int("int", 0, "int value")
+	if err := f.ParseFile("./testdata/test.conf"); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if origin := f.Origin("string2"); origin.String() != "file './testdata/test.conf' line 10" {
+		t.Error("unexpected origin of string2 ", origin)
+	}
+	f.VisitAllOrigin(func(flag *Flag, origin Origin) {
+		if origin.Kind != OriginFile {
+			t.Errorf("origin of %s should be a file, is %v", flag.Name, origin)
+		}
+	})
+}
+
+func TestPrintConfig(t *testing.T) {
+	newFlagSet := func(out io.Writer) *FlagSet {
+		f := NewFlagSet("test", ContinueOnError)
+		f.SetOutput(out)
+		f.Int("pc-port", 80, "port")
+		f.String("pc-name", "", "name")
+		f.EnablePrintConfig()
+		f.SetSources(EnvSource([]string{"PC_NAME=fromEnv"}))
+		return f
+	}
+
+	var b strings.Builder
+	f := newFlagSet(&b)
+	if err := f.Parse([]string{"-pc-port", "8080"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if b.Len() != 0 {
+		t.Error("configuration should not be printed by default:\n", b.String())
+	}
+
+	f = newFlagSet(&b)
+	if err := f.Parse([]string{"-pc-port", "8080", "-print-config"}); err != ErrPrintConfig {
+		t.Fatal("expected ErrPrintConfig, got ", err)
+	}
+	expected := "FLAG     VALUE    DEFAULT  SOURCE\n" +
+		"pc-name  fromEnv           environment variable PC_NAME\n" +
+		"pc-port  8080     80       command line\n"
+	if b.String() != expected {
+		t.Errorf("unexpected table:\n%s\nwant:\n%s", b.String(), expected)
+	}
+
+	b.Reset()
+	f = newFlagSet(&b)
+	if err := f.Parse([]string{"-print-config=json"}); err != ErrPrintConfig {
+		t.Fatal("expected ErrPrintConfig, got ", err)
+	}
+	var entries []configEntry
+	if err := json.Unmarshal([]byte(b.String()), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1] != (configEntry{"pc-port", "80", "80", "default"}) {
+		t.Error("unexpected JSON entries ", entries)
+	}
+
+	b.Reset()
+	f = newFlagSet(&b)
+	if err := f.Parse([]string{"-print-config=yaml"}); err != ErrPrintConfig {
+		t.Fatal("expected ErrPrintConfig, got ", err)
+	}
+	entries = nil
+	if err := yaml.Unmarshal([]byte(b.String()), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != (configEntry{"pc-name", "fromEnv", "", "environment variable PC_NAME"}) {
+		t.Error("unexpected YAML entries ", entries)
+	}
+
+	f = newFlagSet(io.Discard)
+	if err := f.Parse([]string{"-print-config=xml"}); err == nil || !strings.Contains(err.Error(), `unsupported format "xml"`) {
+		t.Error("expected unsupported format error, got ", err)
+	}
+}
+
+// secretIntValue is a secret int value, whose parse errors repeat the value.
+type secretIntValue struct {
+	intValue
+}
+
+func (s *secretIntValue) IsSecret() bool { return true }
+
+func TestSecretFlags(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secretFile, []byte("t0k3n-s3cret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	f := NewFlagSetWithExtras("test", ContinueOnError, "", true, false)
+	f.SetOutput(&out)
+	f.Var(&secretIntValue{}, "sec-token", "token")
+	f.SetSources(EnvSource([]string{"SEC_TOKEN_FILE=" + secretFile}))
+
+	err := f.Parse(nil)
+	if err == nil || !strings.Contains(err.Error(), `invalid value "[redacted]" for environment variable sec-token`) {
+		t.Error("expected redacted invalid value error, got ", err)
+	}
+	if err != nil && strings.Contains(err.Error()+out.String(), "s3cret") {
+		t.Errorf("secret value leaked: %v\n%s", err, out.String())
+	}
+
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	f.Var(&secretIntValue{}, "sec-token", "token")
+	if err := f.Parse([]string{"-sec-token", "s3cret"}); err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Error("expected redacted error, got ", err)
+	}
+	f.SetSources()
+	if err := f.ParseReader(strings.NewReader("sec-token: s3cret\n"), "yaml"); err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Error("expected redacted error, got ", err)
+	}
+
+	out.Reset()
+	f = NewFlagSet("test", ContinueOnError)
+	f.SetOutput(&out)
+	passwordFlag := f.SecretString("sec-password", "default-s3cret", "password")
+	f.String("sec-user", "admin", "user")
+	dsnFlag := f.String("sec-dsn", "", "dsn")
+	f.SetInterpolation(InterpolationStrict)
+	f.EnablePrintConfig()
+	if err := f.Parse([]string{"-sec-password", "s3cret", "-sec-dsn", "admin:${flag:sec-password}@db"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *passwordFlag != "s3cret" {
+		t.Error("password flag should be `s3cret`, is ", *passwordFlag)
+	}
+	if *dsnFlag != "admin:s3cret@db" {
+		t.Error("dsn flag should be `admin:s3cret@db`, is ", *dsnFlag)
+	}
+
+	f.PrintDefaults()
+	if err := f.PrintConfig(&out, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") {
+		t.Error("secret value leaked:\n", out.String())
+	}
+	if !strings.Contains(out.String(), "-sec-password string\n    \tpassword (default [redacted])") {
+		t.Error("default of secret flag should be redacted:\n", out.String())
+	}
+
+	// an explicit write keeps secrets, so the config can be read back
+	var written strings.Builder
+	if err := f.WriteConfig(&written, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(written.String(), "sec-password: s3cret") || !strings.Contains(written.String(), "sec-user: admin") {
+		t.Error("all flags should be written:\n", written.String())
+	}
+	g := NewFlagSet("test", ContinueOnError)
+	readPassword := g.SecretString("sec-password", "", "password")
+	g.String("sec-user", "", "user")
+	g.String("sec-dsn", "", "dsn")
+	if err := g.ParseReader(strings.NewReader(written.String()), "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if *readPassword != "s3cret" {
+		t.Error("password flag should be read back as `s3cret`, is ", *readPassword)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "main.conf")
+	yamlFile := filepath.Join(dir, "more.yaml")
+	write := func(path, content string, age time.Duration) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// make sure the modification time differs
+		mtime := time.Now().Add(age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(mainFile, "w-level info\nw-port 80\nw-name a\nw-fixed a\ninclude more.yaml\n", -time.Hour)
+	write(yamlFile, "w-tags: [x, y]\n", -time.Hour)
+
+	f := NewFlagSet("test", ContinueOnError)
+	levelFlag := f.String("w-level", "warn", "level")
+	portFlag := f.Int("w-port", 0, "port")
+	nameFlag := f.String("w-name", "", "name")
+	fixedFlag := f.String("w-fixed", "", "fixed")
+	tagsFlag := f.StringList("w-tags", nil, "tags")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetReloadable("w-level", "w-port", "w-name", "w-tags")
+	f.SetSources(EnvSource([]string{"W_PORT=8080"}), ConfigFileSource())
+
+	if err := f.Parse([]string{"-config", mainFile, "-w-name", "args"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+
+	type change struct {
+		changed []string
+		err     error
+	}
+	changes := make(chan change, 1)
+	if _, err := f.WatchConfig(0, nil); err == nil {
+		t.Error("expected error for a zero interval")
+	}
+	w, err := f.WatchConfig(5*time.Millisecond, func(changed []string, err error) {
+		changes <- change{changed, err}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	next := func() change {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for reload")
+		}
+		return change{}
+	}
+
+	// touching without changing the content does not reload
+	write(mainFile, "w-level info\nw-port 80\nw-name a\nw-fixed a\ninclude more.yaml\n", 0)
+	write(yamlFile, "w-tags: [x, z]\n", time.Minute)
+	c := next()
+	if c.err != nil || strings.Join(c.changed, ",") != "w-tags" {
+		t.Fatal("expected w-tags to change, got ", c.changed, c.err)
+	}
+	if strings.Join(*tagsFlag, ",") != "x,z" {
+		t.Error("tags flag should be `x,z`, is ", *tagsFlag)
+	}
+
+	write(mainFile, "w-port 81\nw-name b\nw-fixed b\n", 2*time.Minute)
+	c = next()
+	if c.err != nil || strings.Join(c.changed, ",") != "w-level,w-tags" {
+		t.Fatal("expected w-level and w-tags to change, got ", c.changed, c.err)
+	}
+	if *levelFlag != "warn" || f.Origin("w-level").Kind != OriginDefault {
+		t.Error("level flag should be reset to `warn`, is ", *levelFlag)
+	}
+	if len(*tagsFlag) != 0 {
+		t.Error("tags flag should be reset, is ", *tagsFlag)
+	}
+	if *portFlag != 8080 || *nameFlag != "args" || *fixedFlag != "a" {
+		t.Error("flags set by other sources or not reloadable should keep their value, are ", *portFlag, *nameFlag, *fixedFlag)
+	}
+
+	write(mainFile, "w-level debug\n", 3*time.Minute)
+	c = next()
+	if c.err != nil || strings.Join(c.changed, ",") != "w-level" {
+		t.Fatal("expected w-level to change, got ", c.changed, c.err)
+	}
+	if *levelFlag != "debug" || f.Origin("w-level") != (Origin{Kind: OriginFile, File: mainFile, Line: 1}) {
+		t.Error("level flag should be `debug` from line 1, is ", *levelFlag, f.Origin("w-level"))
+	}
+
+	write(mainFile, "w-level error\ninclude missing.conf\n", 4*time.Minute)
+	c = next()
+	if c.err == nil || !strings.Contains(c.err.Error(), "missing.conf") {
+		t.Fatal("expected error reading missing.conf, got ", c.changed, c.err)
+	}
+	if *levelFlag != "debug" {
+		t.Error("level flag should keep `debug` after an error, is ", *levelFlag)
+	}
+}
+
+func TestWatchConfigEntries(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "a.conf"), []byte("we-level info\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	localFile := filepath.Join(dir, "local.conf")
+
+	f := NewFlagSet("test", ContinueOnError)
+	levelFlag := f.String("we-level", "warn", "level")
+	portFlag := f.Int("we-port", 0, "port")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetReloadable("we-level", "we-port")
+	f.SetSources(ConfigFileSource())
+
+	if err := f.Parse([]string{"-config", "?" + localFile + ":" + confDir}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+
+	changes := make(chan []string, 1)
+	w, err := f.WatchConfig(5*time.Millisecond, func(changed []string, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		changes <- changed
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	next := func() []string {
+		select {
+		case changed := <-changes:
+			return changed
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for reload")
+		}
+		return nil
+	}
+
+	// a new file in a directory of the list
+	if err := os.WriteFile(filepath.Join(confDir, "b.conf"), []byte("we-port 81\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed := next(); strings.Join(changed, ",") != "we-port" {
+		t.Fatal("expected we-port to change, got ", changed)
+	}
+
+	// an optional file which comes into existence
+	if err := os.WriteFile(localFile, []byte("we-level debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed := next(); strings.Join(changed, ",") != "we-level" {
+		t.Fatal("expected we-level to change, got ", changed)
+	}
+
+	f.RLock()
+	level, port := *levelFlag, *portFlag
+	f.RUnlock()
+	if level != "debug" || port != 81 {
+		t.Error("flags should be `debug` and 81, are ", level, port)
+	}
+}
+
+func TestReload(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(configFile, []byte("r-port 80\nr-level debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("R_LEVEL", "info")
+	t.Setenv("R_NAME", "env")
+	t.Setenv("R_PORT", "")
+	os.Unsetenv("R_PORT")
+
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	levelFlag := f.String("r-level", "warn", "level")
+	portFlag := f.Int("r-port", 0, "port")
+	nameFlag := f.String("r-name", "", "name")
+	f.String(DefaultConfigFlagname, configFile, "config path")
+	f.SetReloadable("r-level", "r-port", "r-name")
+
+	if err := f.Parse([]string{"-r-name", "args"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if changed, err := f.Reload(); err != nil || len(changed) != 0 {
+		t.Error("reload without changes should not change flags, changed ", changed, err)
+	}
+
+	os.Unsetenv("R_LEVEL")
+	os.Setenv("R_NAME", "env2")
+	changed, err := f.Reload()
+	if err != nil || strings.Join(changed, ",") != "r-level" {
+		t.Fatal("expected r-level to change, got ", changed, err)
+	}
+	if *levelFlag != "debug" || *portFlag != 80 || *nameFlag != "args" {
+		t.Error("unexpected values after reload ", *levelFlag, *portFlag, *nameFlag)
+	}
+	if origin := f.Origin("r-level"); origin.Kind != OriginFile || origin.Line != 2 {
+		t.Error("level flag should be set from line 2 of the file, is ", origin)
+	}
+
+	if err := os.WriteFile(configFile, []byte("r-port 80\nr-level error\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("R_PORT", "x")
+	if _, err := f.Reload(); err == nil || !strings.Contains(err.Error(), `invalid value "x" for environment variable r-port`) {
+		t.Error("expected invalid value error, got ", err)
+	}
+	if *levelFlag != "debug" {
+		t.Error("level flag should be unchanged after an invalid value, is ", *levelFlag)
+	}
+
+	os.Setenv("R_PORT", "8080")
+	reloads := make(chan []string, 1)
+	stop := f.ReloadOnSignal(func(changed []string, err error) {
+		if err != nil {
+			t.Error("reload failed; ", err)
+		}
+		reloads <- changed
+	})
+	defer stop()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(reloadSignal); err != nil {
+		t.Skip("can not send signal; ", err)
+	}
+	select {
+	case changed := <-reloads:
+		if strings.Join(changed, ",") != "r-level,r-port" || *levelFlag != "error" || *portFlag != 8080 {
+			t.Error("expected r-level and r-port to change, got ", changed, *levelFlag, *portFlag)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reload")
+	}
+}
+
+// resettableListValue is a listValue which a reload can reset.
+type resettableListValue struct{ listValue }
+
+func (l *resettableListValue) Reset() { l.listValue = nil }
+
+func TestReloadList(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "app.yaml")
+	if err := os.WriteFile(configFile, []byte("rl-hosts: [a, b]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	f.SetOutput(io.Discard)
+	var hosts resettableListValue
+	f.Var(&hosts, "rl-hosts", "hosts")
+	f.Var(new(listValue), "rl-other", "other hosts")
+	f.String(DefaultConfigFlagname, configFile, "config path")
+	f.SetSources(ConfigFileSource())
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic for a value which can not be reset")
+			}
+		}()
+		f.SetReloadable("rl-other")
+	}()
+	f.SetReloadable("rl-hosts")
+
+	if err := f.Parse(nil); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	for i := 0; i < 2; i++ {
+		if changed, err := f.Reload(); err != nil || len(changed) != 0 {
+			t.Error("reload without changes should not change flags, changed ", changed, err)
+		}
+	}
+	if hosts.String() != "a,b" {
+		t.Error("hosts flag should be `a,b`, is ", hosts.String())
+	}
+
+	if err := os.WriteFile(configFile, []byte("rl-hosts: [c]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := f.Reload(); err != nil || strings.Join(changed, ",") != "rl-hosts" || hosts.String() != "c" {
+		t.Error("expected hosts flag to be replaced by `c`, got ", changed, err, hosts.String())
+	}
+}
+
+func TestReloadConfigFlag(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.conf"), filepath.Join(dir, "second.conf")
+	if err := os.WriteFile(first, []byte("rc-level info\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("rc-level debug\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlagSet("test", ContinueOnError)
+	levelFlag := f.String("rc-level", "warn", "level")
+	f.String(DefaultConfigFlagname, "", "config path")
+	f.SetReloadable("rc-level", DefaultConfigFlagname)
+	env := []string{"CONFIG=" + first}
+	f.SetSources(LookupFunc(func(name string) (string, bool, error) {
+		for _, kv := range env {
+			if key, value, _ := strings.Cut(kv, "="); strings.EqualFold(key, name) {
+				return value, true, nil
+			}
+		}
+		return "", false, nil
+	}), ConfigFileSource())
+
+	if err := f.Parse(nil); err != nil || *levelFlag != "info" {
+		t.Fatal("parse failed; ", err, *levelFlag)
+	}
+	env = []string{"CONFIG=" + second}
+	changed, err := f.Reload()
+	if err != nil || strings.Join(changed, ",") != "config,rc-level" || *levelFlag != "debug" {
+		t.Error("expected the config file named by the reloaded config flag, got ", changed, err, *levelFlag)
+	}
+}
+
+func TestTransactional(t *testing.T) {
+	newFlagSet := func() *FlagSet {
+		f := NewFlagSet("test", ContinueOnError)
+		f.SetOutput(io.Discard)
+		f.String("t-name", "", "name")
+		f.StringList("t-tags", []string{"default"}, "tags")
+		f.TextVar(new(net.IP), "t-ip", net.IPv4(127, 0, 0, 1), "ip")
+		f.Int("t-port", 0, "port")
+		f.String("t-level", "", "level")
+		f.String(DefaultConfigFlagname, "./testdata/transaction.conf", "config path")
+		f.SetSources(EnvSource([]string{"T_IP=10.0.0.1"}), ConfigFileSource())
+		return f
+	}
+
+	// without transactions the values before the invalid one are set
+	f := newFlagSet()
+	if err := f.Parse(nil); err == nil {
+		t.Fatal("expected invalid value error")
+	}
+	if f.Lookup("t-name").Value.String() != "fromFile" {
+		t.Error("name flag should be set before the invalid value, is ", f.Lookup("t-name").Value)
+	}
+
+	f = newFlagSet()
+	f.SetTransactional(true)
+	err := f.Parse([]string{"-t-level", "info"})
+	if err == nil || !strings.Contains(err.Error(), `invalid value "x" for configuration variable t-port: parse error`) {
+		t.Fatal("expected invalid value error, got ", err)
+	}
+	for name, value := range map[string]string{"t-name": "", "t-tags": "default", "t-ip": "127.0.0.1", "t-port": "0", "t-level": "info"} {
+		if f.Lookup(name).Value.String() != value {
+			t.Errorf("%s flag should be %q, is %q", name, value, f.Lookup(name).Value)
+		}
+	}
+	if len(f.actual) != 1 || f.actual["t-level"] == nil {
+		t.Error("only the command line flag should be set, set are ", f.actual)
+	}
+
+	f = newFlagSet()
+	f.SetTransactional(true)
+	if err := f.Parse([]string{"-t-port", "80"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	for name, value := range map[string]string{"t-name": "fromFile", "t-tags": "a", "t-ip": "10.0.0.1", "t-port": "80", "t-level": "debug"} {
+		if f.Lookup(name).Value.String() != value {
+			t.Errorf("%s flag should be %q, is %q", name, value, f.Lookup(name).Value)
+		}
+	}
+	if origin := f.Origin("t-level"); origin != (Origin{Kind: OriginFile, File: "./testdata/transaction.conf", Line: 3}) {
+		t.Error("unexpected origin of level flag ", origin)
+	}
+}
+
+// enumValue is a value which accepts only the values it is defined with.
+type enumValue struct {
+	allowed []string
+	p       *string
+}
+
+func (e enumValue) String() string {
+	if e.p == nil {
+		return ""
+	}
+	return *e.p
+}
+
+func (e enumValue) Set(s string) error {
+	if err := e.Validate(s); err != nil {
+		return err
+	}
+	*e.p = s
+	return nil
+}
+
+func (e enumValue) Validate(s string) error {
+	for _, allowed := range e.allowed {
+		if s == allowed {
+			return nil
+		}
+	}
+	return errors.New("not allowed")
+}
+
+func TestTransactionalValidation(t *testing.T) {
+	dir := t.TempDir()
+	validFile := filepath.Join(dir, "valid.yaml")
+	invalidFile := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(validFile, []byte("tr-hosts: [a, b]\ntr-tags: [x, y]\ntr-func: f\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalidFile, []byte("tr-hosts: [a, b]\ntr-func: f\ntr-count: x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	newFlagSet := func(configFile, mode string) (*FlagSet, *string, *listValue, *[]string) {
+		var hosts listValue
+		modeValue := "b"
+		f := NewFlagSet("test", ContinueOnError)
+		f.SetOutput(io.Discard)
+		f.Var(enumValue{[]string{"a", "b"}, &modeValue}, "tr-mode", "mode")
+		f.Var(&hosts, "tr-hosts", "hosts")
+		tags := f.StringList("tr-tags", []string{"default"}, "tags")
+		f.Func("tr-func", "func", func(s string) error { calls = append(calls, s); return nil })
+		f.Int("tr-port", 0, "port")
+		f.Int("tr-count", 0, "count")
+		f.String(DefaultConfigFlagname, "", "config path")
+		f.SetTransactional(true)
+		// the config file is only given by the environment
+		f.SetSources(EnvSource([]string{"TR_MODE=" + mode, "CONFIG=" + configFile}), ConfigFileSource())
+		return f, &modeValue, &hosts, tags
+	}
+
+	f, mode, hosts, tags := newFlagSet(validFile, "a")
+	if err := f.Parse([]string{"-tr-port", "1"}); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if *mode != "a" || hosts.String() != "a,b" || strings.Join(*tags, ",") != "x,y" || strings.Join(calls, ",") != "f" {
+		t.Error("unexpected values ", *mode, *hosts, *tags, calls)
+	}
+
+	// invalid values are found before any flag is set
+	for _, c := range []struct{ file, mode, flag string }{
+		{invalidFile, "a", "tr-count"},
+		{validFile, "c", "tr-mode"},
+	} {
+		calls = nil
+		f, mode, hosts, tags = newFlagSet(c.file, c.mode)
+		err := f.Parse([]string{"-tr-port", "1"})
+		if err == nil || !strings.Contains(err.Error(), c.flag) {
+			t.Fatal("expected invalid value error, got ", err)
+		}
+		if *mode != "b" || len(*hosts) != 0 || strings.Join(*tags, ",") != "default" || len(calls) != 0 {
+			t.Error("flags should not be set, are ", *mode, *hosts, *tags, calls)
+		}
+		if len(f.actual) != 1 || f.actual["tr-port"] == nil || f.Origin("tr-mode").Kind != OriginDefault {
+			t.Error("only the command line flag should be set, set are ", f.actual)
+		}
+	}
+
+	// standard input is read by transactional parses
+	f, _, _, tags = newFlagSet("-", "a")
+	f.stdin = strings.NewReader("tr-tags stdin\n")
+	if err := f.Parse(nil); err != nil {
+		t.Fatal("parse failed; ", err)
+	}
+	if strings.Join(*tags, ",") != "stdin" {
+		t.Error("tags flag should be read from standard input, is ", *tags)
+	}
+}
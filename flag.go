@@ -541,6 +541,7 @@ func (f *FlagSet) set(name, value string) error {
 		f.actual = make(map[string]*Flag)
 	}
 	f.actual[name] = flag
+	f.setOrigin(name, Origin{Kind: OriginSet}) /* smartpricer/flag */
 	return nil
 }
 
@@ -609,7 +610,7 @@ func UnquoteUsage(flag *Flag) (name string, usage string) {
 		name = "float"
 	case *intValue, *int64Value:
 		name = "int"
-	case *stringValue:
+	case *stringValue, *secretStringValue: /* smartpricer/flag */
 		name = "string"
 	case *uintValue, *uint64Value:
 		name = "uint"
@@ -646,7 +647,9 @@ func (f *FlagSet) PrintDefaults() {
 		if isZero, err := isZeroValue(flag, flag.DefValue); err != nil {
 			isZeroValueErrs = append(isZeroValueErrs, err)
 		} else if !isZero {
-			if _, ok := flag.Value.(*stringValue); ok {
+			if isSecret(flag) { /* smartpricer/flag */
+				fmt.Fprintf(&b, " (default %s)", redactedValue)
+			} else if _, ok := flag.Value.(*stringValue); ok {
 				// put quotes on the value
 				fmt.Fprintf(&b, " (default %q)", flag.DefValue)
 			} else {
@@ -1134,7 +1137,8 @@ func (f *FlagSet) parseOne() (bool, error) {
 	if fv, ok := flag.Value.(boolFlag); ok && fv.IsBoolFlag() { // special case: doesn't need an arg
 		if hasValue {
 			if err := fv.Set(value); err != nil {
-				return false, f.failf("invalid boolean value %q for -%s: %v", value, name, err)
+				shown, err := redact(flag, value, err) /* smartpricer/flag */
+				return false, f.failf("invalid boolean value %q for -%s: %v", shown, name, err)
 			}
 		} else {
 			if err := fv.Set("true"); err != nil {
@@ -1152,13 +1156,15 @@ func (f *FlagSet) parseOne() (bool, error) {
 			return false, f.failf("flag needs an argument: -%s", name)
 		}
 		if err := flag.Value.Set(value); err != nil {
-			return false, f.failf("invalid value %q for flag -%s: %v", value, name, err)
+			shown, err := redact(flag, value, err) /* smartpricer/flag */
+			return false, f.failf("invalid value %q for flag -%s: %v", shown, name, err)
 		}
 	}
 	if f.actual == nil {
 		f.actual = make(map[string]*Flag)
 	}
 	f.actual[name] = flag
+	f.setOrigin(name, Origin{Kind: OriginCommandLine}) /* smartpricer/flag */
 	return true, nil
 }
 
@@ -1200,54 +1206,55 @@ func (f *FlagSet) Parse(arguments []string) error {
 
 func (f *FlagSet) parseExtras() error {
 	// /* jnovack/flag BEGIN */
-	// Parse environment variables
-	if err := f.ParseEnv(os.Environ()); err != nil {
-		switch f.errorHandling {
-		case ContinueOnError:
-			return err
-		case ExitOnError:
-			if err == ErrHelp {
-				os.Exit(0)
-			}
-			os.Exit(2)
-		case PanicOnError:
-			panic(err)
+	f.stdinRead = false
+	f.configRead, f.configExpanded = nil, nil
+
+	// Parse the sources, in order of precedence
+	sources := f.configSources()
+	actual := f.stageSources()
+	f.shadowCommandLine(sources)
+	for _, source := range sources {
+		if err := source.Apply(f); err != nil {
+			f.abortStaging(actual)
+			return f.handleError(err)
 		}
-		return err
 	}
 
-	// Parse configuration from file
-	var cFile string
-	if cf := f.formal[DefaultConfigFlagname]; cf != nil {
-		cFile = cf.Value.String()
+	// Set the staged values, all or none
+	if err := f.commitStaged(actual); err != nil {
+		return f.handleError(err)
 	}
-	if cf := f.actual[DefaultConfigFlagname]; cf != nil {
-		cFile = cf.Value.String()
+
+	// Resolve references to other flags
+	if err := f.resolveFlagReferences(); err != nil {
+		return f.handleError(err)
 	}
-	if cFile != "" {
-		singleFiles := strings.Split(cFile, ":")
-
-		for _, singleFile := range singleFiles {
-			if err := f.ParseFile(singleFile); err != nil {
-				switch f.errorHandling {
-				case ContinueOnError:
-					return err
-				case ExitOnError:
-					if err == ErrHelp {
-						os.Exit(0)
-					}
-					os.Exit(2)
-				case PanicOnError:
-					panic(err)
-				}
-				return err
-			}
-		}
+
+	// Print the configuration if requested
+	if err := f.printConfigRequested(); err != nil {
+		return f.handleError(err)
 	}
 	// /* jnovack/flag END */
 	return nil
 }
 
+// handleError handles err, returned while parsing the sources, according to
+// the error handling of f.
+func (f *FlagSet) handleError(err error) error { /* smartpricer/flag */
+	switch f.errorHandling {
+	case ContinueOnError:
+		return err
+	case ExitOnError:
+		if err == ErrHelp || err == ErrPrintConfig {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	case PanicOnError:
+		panic(err)
+	}
+	return err
+}
+
 // Parsed reports whether f.Parse has been called.
 func (f *FlagSet) Parsed() bool {
 	return f.parsed
@@ -2,4 +2,7 @@ module github.com/smartpricer/flag
 
 go 1.22.1
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require (
+	github.com/BurntSushi/toml v1.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,11 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flag
+
+import "os"
+
+// reloadSignal is the default signal of ReloadOnSignal, nil as the port has
+// no SIGHUP.
+var reloadSignal os.Signal
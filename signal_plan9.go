@@ -0,0 +1,13 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flag
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignal is the default signal of ReloadOnSignal.
+var reloadSignal os.Signal = syscall.Note("hangup")